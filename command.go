@@ -0,0 +1,238 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/adriangonzy/websocket-balls/game"
+	"github.com/adriangonzy/websocket-balls/ws"
+)
+
+// Commands the viewers can send over their websocket connection.
+const (
+	cmdStart      = "start"
+	cmdStop       = "stop"
+	cmdPause      = "pause"
+	cmdResume     = "resume"
+	cmdStep       = "step"
+	cmdSetConfig  = "set-config"
+	cmdSpawnBall  = "spawn-ball"
+	cmdRemoveBall = "remove-ball"
+	cmdClear      = "clear-balls"
+	cmdTimeScale  = "set-time-scale"
+	cmdHeadless   = "set-headless"
+	cmdRecord     = "record"
+	cmdStopRec    = "stop-recording"
+	cmdSeek       = "seek"
+	cmdResync     = "resync"
+	cmdStats      = "stats"
+	cmdViewport   = "set-viewport"
+)
+
+// command is a client to server message, e.g.
+//
+//	{"type": "start", "config": {...}}
+//	{"type": "set-config", "config": {"frameRate": 60}}
+//	{"type": "step", "steps": 10}
+//	{"type": "spawn-ball", "x": 120, "y": 40}
+//	{"type": "spawn-ball", "ball": {"x": 120, "y": 40, "vx": 2, "radius": 0.5, "color": "#f00"}}
+//	{"type": "remove-ball", "id": 3}
+//	{"type": "set-time-scale", "scale": 0.5}
+//	{"type": "record", "name": "glued-balls"}
+//	{"type": "seek", "frame": 120}
+//	{"type": "stats"}
+//	{"type": "set-viewport", "viewport": {"x": 0, "y": 0, "width": 800, "height": 600, "zoom": 1}}
+type command struct {
+	Type   string          `json:"type"`
+	Config json.RawMessage `json:"config,omitempty"`
+	Steps  int             `json:"steps,omitempty"`
+
+	Scale    float64 `json:"scale,omitempty"`
+	Headless bool    `json:"headless,omitempty"`
+
+	// recording name and replay frame index
+	Name  string `json:"name,omitempty"`
+	Frame int    `json:"frame,omitempty"`
+
+	// area of the world displayed by the viewer, the whole world when not
+	// given
+	Viewport *viewport `json:"viewport,omitempty"`
+
+	// spawned ball, random when not given, or at least its position in
+	// pixels
+	Ball *game.BallSpec `json:"ball,omitempty"`
+	X    *float64       `json:"x,omitempty"`
+	Y    *float64       `json:"y,omitempty"`
+
+	// removed ball ID
+	ID *int `json:"id,omitempty"`
+}
+
+// reply is a server to client message that is not a frame. Frames are sent as
+// JSON arrays, replies as JSON objects.
+//
+// Failed commands get an error reply, listing the invalid fields when given
+// an invalid config.
+type reply struct {
+	Type    string `json:"type"`
+	Session string `json:"session,omitempty"`
+	Error   string `json:"error,omitempty"`
+
+	// invalid config or ball fields along with an error
+	Errors game.ValidationError `json:"errors,omitempty"`
+
+	// ID of the spawned ball
+	ID *int `json:"id,omitempty"`
+
+	Stats *stats `json:"stats,omitempty"`
+
+	// obstacles of the world in pixels, none when left out
+	Obstacles []game.Obstacle `json:"obstacles,omitempty"`
+}
+
+// stats counts the frames dropped because of slow viewers.
+type stats struct {
+	Policy         string `json:"policy"`
+	Dropped        uint64 `json:"dropped"`        // for the viewer
+	SessionDropped uint64 `json:"sessionDropped"` // for every session viewer
+}
+
+// worldReply returns the reply describing the world geometry of the config,
+// sent once to the viewers since it never moves.
+func worldReply(c *game.Config) reply {
+	r := reply{Type: "world"}
+	for _, o := range c.Obstacles {
+		r.Obstacles = append(r.Obstacles, o.Pixels())
+	}
+	return r
+}
+
+// errorReply returns the reply to a command which failed with err.
+func errorReply(err error) reply {
+	r := reply{Type: "error", Error: err.Error()}
+	if errs, ok := err.(game.ValidationError); ok {
+		r.Errors = errs
+	}
+	return r
+}
+
+func serializeReply(r reply) []byte {
+	b, err := json.Marshal(r)
+	if err != nil {
+		panic(err)
+	}
+	return b
+}
+
+// handle decodes and runs a command sent to the session by a viewer.
+func (s *session) handle(conn *ws.Connection, data []byte) error {
+	var cmd command
+	if err := json.Unmarshal(data, &cmd); err != nil {
+		return fmt.Errorf("invalid command: %v", err)
+	}
+
+	// config defaults are overridden by the given fields, set-config
+	// overrides the session config instead
+	var config *game.Config
+	if cmd.Config != nil && cmd.Type != cmdSetConfig {
+		var err error
+		if config, err = decodeConfig(cmd.Config); err != nil {
+			return err
+		}
+	}
+
+	switch cmd.Type {
+	case cmdStart:
+		return s.start(config)
+	case cmdStop:
+		return s.stop()
+	case cmdSetConfig:
+		if cmd.Config == nil {
+			return errNoConfig
+		}
+		_, err := s.updateConfig(cmd.Config)
+		return err
+	case cmdSpawnBall, cmdRemoveBall, cmdClear:
+		sim, err := s.simulation()
+		if err != nil {
+			return err
+		}
+		switch cmd.Type {
+		case cmdSpawnBall:
+			spec := cmd.Ball
+			if spec == nil {
+				spec = &game.BallSpec{X: cmd.X, Y: cmd.Y}
+			}
+			id, err := sim.AddBall(spec)
+			if err != nil {
+				return err
+			}
+			s.hub.SendTo(conn, serializeReply(reply{Type: "ball", ID: &id}))
+		case cmdRemoveBall:
+			if cmd.ID == nil {
+				return errNoBall
+			}
+			return sim.RemoveBall(*cmd.ID)
+		case cmdClear:
+			sim.Clear()
+		}
+		return nil
+	case cmdHeadless:
+		sim, err := s.simulation()
+		if err != nil {
+			return err
+		}
+		sim.SetHeadless(cmd.Headless)
+		return nil
+	case cmdPause, cmdResume, cmdStep, cmdTimeScale:
+		p, err := s.playback()
+		if err != nil {
+			return err
+		}
+		switch cmd.Type {
+		case cmdPause:
+			p.Pause()
+		case cmdResume:
+			p.Resume()
+		case cmdStep:
+			if cmd.Steps <= 0 {
+				cmd.Steps = 1
+			}
+			p.Step(cmd.Steps)
+		case cmdTimeScale:
+			p.SetTimeScale(cmd.Scale)
+		}
+		return nil
+	case cmdSeek:
+		p, err := s.replayer()
+		if err != nil {
+			return err
+		}
+		p.Seek(cmd.Frame)
+		return nil
+	case cmdResync:
+		// the viewer lost track of the delta frames
+		if e, ok := conn.Encoder().(*frameEncoder); ok {
+			e.Resync()
+		}
+		return nil
+	case cmdViewport:
+		e, ok := conn.Encoder().(*frameEncoder)
+		if !ok {
+			return fmt.Errorf("viewports are not supported by the connection")
+		}
+		return e.SetViewport(cmd.Viewport)
+	case cmdStats:
+		s.hub.SendTo(conn, serializeReply(reply{Type: cmdStats, Stats: &stats{
+			Policy:         conn.Policy().String(),
+			Dropped:        conn.Dropped(),
+			SessionDropped: s.hub.Dropped(),
+		}}))
+		return nil
+	case cmdRecord:
+		return s.startRecording(cmd.Name)
+	case cmdStopRec:
+		return s.stopRecording()
+	}
+	return fmt.Errorf("unknown command %q", cmd.Type)
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/adriangonzy/websocket-balls/game"
+	"github.com/adriangonzy/websocket-balls/ws"
+)
+
+func TestHandleCommands(t *testing.T) {
+	m := newSessionManager()
+	s := m.create()
+	defer m.stop(s.id)
+	conn := ws.NewConnection(s.hub, make(chan interface{}, 16), nil, nil)
+	handle := func(data string) error {
+		t.Helper()
+		return s.handle(conn, []byte(data))
+	}
+
+	for _, data := range []string{`{`, `{"type": "fly"}`, `{"type": "pause"}`, `{"type": "start"}`} {
+		if err := handle(data); err == nil {
+			t.Errorf("Expected %s to fail before a simulation starts", data)
+		}
+	}
+	if _, ok := handle(`{"type": "start", "config": {"frameRate": 0}}`).(game.ValidationError); !ok {
+		t.Error("Expected the invalid config fields to be listed")
+	}
+
+	if err := handle(`{"type": "start", "config": {"ballCount": 3, "placement": "grid"}}`); err != nil {
+		t.Fatal(err)
+	}
+	sim, err := s.simulation()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, data := range []string{
+		`{"type": "pause"}`,
+		`{"type": "step", "steps": 2}`,
+		`{"type": "resume"}`,
+		`{"type": "spawn-ball", "ball": {"radius": 0.5}}`,
+		`{"type": "remove-ball", "id": 0}`,
+		`{"type": "set-config", "config": {"frameRate": 60}}`,
+	} {
+		if err := handle(data); err != nil {
+			t.Errorf("Expected %s to succeed, got %v", data, err)
+		}
+	}
+	if n := len(sim.Snapshot().Balls); n != 3 {
+		t.Error("Expected 3 balls after a spawn and a removal, got", n)
+	}
+	if c := sim.Config(); c.FrameRate != 60 || c.BallCount != 3 {
+		t.Errorf("Expected the config to be patched, got %+v", c)
+	}
+	if err := handle(`{"type": "remove-ball"}`); err != errNoBall {
+		t.Errorf("Expected %v, got %v", errNoBall, err)
+	}
+	if err := handle(`{"type": "set-config"}`); err != errNoConfig {
+		t.Errorf("Expected %v, got %v", errNoConfig, err)
+	}
+
+	if err := handle(`{"type": "stop"}`); err != nil {
+		t.Fatal(err)
+	}
+	if err := handle(`{"type": "spawn-ball"}`); err != errNotRunning {
+		t.Errorf("Expected %v once stopped, got %v", errNotRunning, err)
+	}
+	// the last config is kept for the next start
+	if err := handle(`{"type": "start"}`); err != nil {
+		t.Error(err)
+	}
+}
@@ -5,84 +5,311 @@ import (
 	"log"
 
 	"encoding/json"
+	"io/ioutil"
 	"net/http"
+	"strconv"
 
 	"github.com/adriangonzy/websocket-balls/game"
+	"github.com/adriangonzy/websocket-balls/record"
 	"github.com/adriangonzy/websocket-balls/ws"
 )
 
-var conn *ws.Connection
-var sim *game.Simulation
+var sessions = newSessionManager()
 
 func bindSimulationControls() {
+	ws.Upgrader.Subprotocols = []string{protoBinary, protoJSON}
 	http.HandleFunc("/simulation/start", startSimulation)
 	http.HandleFunc("/simulation/stop", stopSimulation)
+	http.HandleFunc("/simulation/config", updateConfig)
+	http.HandleFunc("/simulation/balls", editBalls)
+	http.HandleFunc("/simulation/snapshot", downloadSnapshot)
+	http.HandleFunc("/simulation/restore", uploadSnapshot)
 	http.HandleFunc("/ws", serveWs)
 }
 
-func getConfig(req *http.Request) *game.Config {
-	decoder := json.NewDecoder(req.Body)
-	fmt.Printf("request body %#v \n", req.Body)
-	var t game.Config
-	err := decoder.Decode(&t)
-	if err != nil {
-		panic(err)
+// decodeConfig decodes a JSON config over the defaults and validates it.
+func decodeConfig(data []byte) (*game.Config, error) {
+	c := game.NewConfig()
+	if err := patchConfig(c, data); err != nil {
+		return nil, err
 	}
-	return &t
+	return c, nil
 }
 
-func startSimulation(w http.ResponseWriter, r *http.Request) {
+// patchConfig decodes the fields of a JSON config over c and validates the
+// result.
+func patchConfig(c *game.Config, data []byte) error {
+	if err := json.Unmarshal(data, c); err != nil {
+		if e, ok := err.(*json.UnmarshalTypeError); ok && e.Field != "" {
+			return game.ValidationError{{Field: e.Field, Error: "must be a " + e.Type.String()}}
+		}
+		return fmt.Errorf("invalid config: %v", err)
+	}
+	return c.Validate()
+}
 
-	if conn == nil {
-		http.Error(w, "Must upgrade to websocket connection before starting simulation", http.StatusInternalServerError)
-		log.Fatal("Must upgrade to websocket connection before starting simulation")
+// fieldErrors replies with a 400 error listing the invalid config or ball
+// fields, a single one with no field name for other errors, e.g.
+//
+//	{"errors": [{"field": "frameRate", "error": "must be between 1 and 1000"}]}
+func fieldErrors(w http.ResponseWriter, err error) {
+	errs, ok := err.(game.ValidationError)
+	if !ok {
+		errs = game.ValidationError{{Error: err.Error()}}
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(http.StatusBadRequest)
+	json.NewEncoder(w).Encode(map[string]game.ValidationError{"errors": errs})
+}
+
+// startSimulation starts a new session and replies with its ID, to be given
+// to /ws?session=<id> for watching it.
+func startSimulation(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", 405)
 		return
 	}
 
-	c := getConfig(r)
+	data, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	c, err := decodeConfig(data)
+	if err != nil {
+		fieldErrors(w, err)
+		return
+	}
 
 	// init simulation with given number of balls
-	sim = game.NewSimulation(c)
-	sim.Start()
+	s, err := sessions.start(c)
+	if err != nil {
+		fieldErrors(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	json.NewEncoder(w).Encode(map[string]string{"session": s.id})
+}
+
+func stopSimulation(w http.ResponseWriter, r *http.Request) {
+	if err := sessions.stop(r.URL.Query().Get("session")); err != nil {
+		http.Error(w, "Must start simulation before stopping it", http.StatusNotFound)
+		return
+	}
+}
+
+// updateConfig applies the fields of the PATCH body to the session config,
+// and to its simulation between two frames when running. It replies with the
+// resulting config.
+func updateConfig(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "PATCH" {
+		http.Error(w, "Method not allowed", 405)
+		return
+	}
+
+	s, err := sessions.get(r.URL.Query().Get("session"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	data, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	c, err := s.updateConfig(data)
+	if err != nil {
+		fieldErrors(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	json.NewEncoder(w).Encode(c)
+}
+
+// editBalls adds the ball described by the POST body to the session
+// simulation and replies with its ID, or removes the ball given by the id
+// query parameter on DELETE, every ball when not given.
+func editBalls(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" && r.Method != "DELETE" {
+		http.Error(w, "Method not allowed", 405)
+		return
+	}
+
+	s, err := sessions.get(r.URL.Query().Get("session"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	sim, err := s.simulation()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
 
-	go func() {
-		for balls := range sim.Emit {
-			conn.Send <- serializeBalls(balls)
+	if r.Method == "DELETE" {
+		param := r.URL.Query().Get("id")
+		if param == "" {
+			sim.Clear()
+			return
+		}
+		id, err := strconv.Atoi(param)
+		if err != nil {
+			http.Error(w, "invalid ball ID", http.StatusBadRequest)
+			return
 		}
-	}()
+		if err := sim.RemoveBall(id); err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+		}
+		return
+	}
+
+	var spec game.BallSpec
+	if err := json.NewDecoder(r.Body).Decode(&spec); err != nil {
+		fieldErrors(w, fmt.Errorf("invalid ball: %v", err))
+		return
+	}
+	id, err := sim.AddBall(&spec)
+	if err != nil {
+		fieldErrors(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	json.NewEncoder(w).Encode(map[string]int{"id": id})
 }
 
-func serializeBalls(balls [][]interface{}) []byte {
-	b, e := json.Marshal(balls)
-	if e != nil {
-		fmt.Errorf("%v", e)
-		return nil
+// downloadSnapshot replies with the state of the session simulation as a JSON
+// file.
+func downloadSnapshot(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", 405)
+		return
+	}
+
+	id := r.URL.Query().Get("session")
+	s, err := sessions.get(id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	sim, err := s.simulation()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	sn := sim.Snapshot()
+	if sn == nil {
+		http.Error(w, errNotRunning.Error(), http.StatusConflict)
+		return
 	}
-	return b
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=snapshot-%s-%d.json", id, sn.Frames))
+	json.NewEncoder(w).Encode(sn)
 }
 
-func stopSimulation(w http.ResponseWriter, r *http.Request) {
-	if sim == nil {
-		http.Error(w, "Must start simulation before stopping it", http.StatusInternalServerError)
+// uploadSnapshot restores the posted snapshot in the given session, or in a
+// new one whose ID is sent back when no session is given.
+func uploadSnapshot(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", 405)
+		return
+	}
+
+	var sn game.Snapshot
+	if err := json.NewDecoder(r.Body).Decode(&sn); err != nil {
+		http.Error(w, "invalid snapshot: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	id := r.URL.Query().Get("session")
+	var s *session
+	if id != "" {
+		var err error
+		if s, err = sessions.get(id); err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+	} else {
+		s = sessions.create()
+	}
+	if err := s.restore(&sn); err != nil {
+		if id == "" {
+			sessions.stop(s.id)
+		}
+		fieldErrors(w, err)
 		return
 	}
 
-	sim.Stop()
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	json.NewEncoder(w).Encode(map[string]string{"session": s.id})
 }
 
-// serverWs handles webocket requests from the peer.
+// serverWs handles webocket requests from the peer. The peer watches the
+// session given by the session query parameter, or the replay of the
+// recording given by the replay one at the given speed, and can send it
+// commands.
 func serveWs(w http.ResponseWriter, r *http.Request) {
 	if r.Method != "GET" {
 		http.Error(w, "Method not allowed", 405)
 		return
 	}
 
+	// join the given session, replay a recording in a new session, or open
+	// a new one driven by websocket commands, both closed once their last
+	// viewer leaves
+	var s *session
+	var rec *record.Recording
+	if id := r.URL.Query().Get("session"); id != "" {
+		var err error
+		if s, err = sessions.get(id); err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+	} else if name := r.URL.Query().Get("replay"); name != "" {
+		var err error
+		if rec, err = record.Load(recordPath(name)); err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+	}
+
+	// what to do with the frames a slow viewer cannot keep up with, see
+	// ws.Policy
+	policy := ws.Coalesce
+	if name := r.URL.Query().Get("policy"); name != "" {
+		var err error
+		if policy, err = ws.ParsePolicy(name); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	maxMissed, _ := strconv.Atoi(r.URL.Query().Get("maxMissed"))
+
 	websocket, err := ws.Upgrader.Upgrade(w, r, nil)
 	if err != nil {
 		log.Println("Error Upgrading", err)
 		return
 	}
-	conn = ws.NewConnection(make(chan []byte, 256), websocket)
-	conn.Start()
+	switch {
+	case rec != nil:
+		speed, _ := strconv.ParseFloat(r.URL.Query().Get("speed"), 64)
+		s = sessions.replay(rec, speed)
+	case s == nil:
+		s = sessions.create()
+		go sessions.reap(s, s.hub.Empty())
+	}
+
+	// tell the peer which session it joined before any frame
+	send := make(chan interface{}, 256)
+	send <- serializeReply(reply{Type: "session", Session: s.id})
+	if world := s.world(); world != nil {
+		send <- world
+	}
+	conn := ws.NewConnection(s.hub, send, websocket, newFrameEncoder(websocket.Subprotocol()))
+	conn.SetPolicy(policy, maxMissed)
 	log.Println("Connection STARTED")
+	conn.Start()
 }
@@ -0,0 +1,373 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+	"sync/atomic"
+
+	"github.com/adriangonzy/websocket-balls/game"
+	"github.com/adriangonzy/websocket-balls/quadtree"
+	"github.com/gorilla/websocket"
+)
+
+// Websocket subprotocols of the frame stream, the binary one being preferred
+// when the peer offers both. Peers asking for none get JSON.
+const (
+	protoBinary = "balls.binary.v2"
+	protoJSON   = "balls.json.v1"
+)
+
+// binaryVersion is the first byte of every binary frame.
+//
+// Binary frames are either keyframes holding every ball, or deltas from the
+// previous frame sent to the peer. Positions are quantized to 1/positionUnits
+// pixel and angles to 1/angleUnits turn. A binary frame is, in little endian:
+//
+//	uint8   version
+//	uint8   kind, keyframe or delta
+//	uint32  frame number
+//	uint16  palette length, then for each colour:
+//	        uint8 length, colour string bytes
+//	uint32  full ball count, then for each ball, the new ones for deltas:
+//	        uint32 id, int32 x, int32 y, float32 radius, uint16 angle,
+//	        uint16 palette index
+//
+// followed for deltas by:
+//
+//	uint32  moved ball count, then for each ball:
+//	        uint32 id, int16 dx, int16 dy, int16 dangle
+//	uint32  removed ball count, then for each ball:
+//	        uint32 id
+//
+// Viewers which set a viewport only get the balls inside it: the balls
+// entering it are sent in full and the ones leaving it as removed.
+const binaryVersion = 2
+
+const (
+	keyframe = 0
+	delta    = 1
+
+	positionUnits = 8
+	angleUnits    = 1 << 16
+
+	// frames sent between two keyframes
+	keyframeInterval = 100
+)
+
+// quantBall is a ball as sent in binary frames.
+type quantBall struct {
+	x, y   int32
+	angle  uint16
+	radius float32
+	color  string
+}
+
+func quantize(b game.BallState) quantBall {
+	turns := b.Angle / (2 * math.Pi)
+	return quantBall{
+		x:      int32(math.Floor(b.X*positionUnits + 0.5)),
+		y:      int32(math.Floor(b.Y*positionUnits + 0.5)),
+		angle:  uint16(int64(math.Floor((turns-math.Floor(turns))*angleUnits)) % angleUnits),
+		radius: float32(b.Radius),
+		color:  b.Color,
+	}
+}
+
+// encodedFrame is a frame broadcast to the session viewers, encoded once per
+// format the first time a viewer needs it. Deltas depend on what each viewer
+// got before and are encoded per viewer.
+type encodedFrame struct {
+	frame *game.Frame
+
+	jsonOnce     sync.Once
+	json         []byte
+	jsonErr      error
+	keyframeOnce sync.Once
+	quantized    map[int]quantBall
+	keyframe     []byte
+	treeOnce     sync.Once
+	tree         *ballTree
+}
+
+func newEncodedFrame(f *game.Frame) *encodedFrame {
+	return &encodedFrame{frame: f}
+}
+
+func (f *encodedFrame) JSON() ([]byte, error) {
+	f.jsonOnce.Do(func() {
+		f.json, f.jsonErr = serializeBalls(f.frame)
+	})
+	return f.json, f.jsonErr
+}
+
+// Keyframe returns the binary keyframe, along with the quantized balls by ID.
+func (f *encodedFrame) Keyframe() ([]byte, map[int]quantBall) {
+	f.keyframeOnce.Do(func() {
+		f.quantized = quantizeFrame(f.frame)
+		f.keyframe = encodeBinaryFrame(f.frame, keyframe, f.frame.Balls, f.quantized, nil, nil)
+	})
+	return f.keyframe, f.quantized
+}
+
+// Inside returns the frame with only the balls inside the area, the balls
+// being indexed once for every viewer.
+func (f *encodedFrame) Inside(area *quadtree.Box) *game.Frame {
+	f.treeOnce.Do(func() {
+		f.tree = newBallTree(f.frame)
+	})
+	indexes := f.tree.search(area)
+	inside := &game.Frame{Number: f.frame.Number, Balls: make([]game.BallState, len(indexes))}
+	for i, j := range indexes {
+		inside.Balls[i] = f.frame.Balls[j]
+	}
+	return inside
+}
+
+func quantizeFrame(f *game.Frame) map[int]quantBall {
+	balls := make(map[int]quantBall, len(f.Balls))
+	for _, b := range f.Balls {
+		balls[b.Id] = quantize(b)
+	}
+	return balls
+}
+
+// frameEncoder encodes frames in the format negotiated with a viewer. Other
+// messages are already JSON encoded replies.
+//
+// Binary viewers get a keyframe when joining, every keyframeInterval frames
+// and when asking for a resync, deltas from the last frame sent otherwise.
+// Frames dropped before being encoded thus never break the delta chain.
+type frameEncoder struct {
+	binary bool
+
+	resync   int32 // set when the viewer asks for a keyframe
+	sent     map[int]quantBall
+	sinceKey int
+
+	// the world area the viewer wants the balls of, all of them when nil
+	mu   sync.Mutex
+	area *quadtree.Box
+
+	// IDs of the balls inside the area sent to a JSON viewer
+	visible map[int]bool
+}
+
+func newFrameEncoder(subprotocol string) *frameEncoder {
+	return &frameEncoder{binary: subprotocol == protoBinary}
+}
+
+// Resync makes the next frame a keyframe. It is safe to call from any
+// goroutine.
+func (e *frameEncoder) Resync() {
+	atomic.StoreInt32(&e.resync, 1)
+}
+
+// SetViewport restricts the balls sent to the ones inside the viewport, or
+// lifts the restriction when v is nil. It is safe to call from any goroutine.
+func (e *frameEncoder) SetViewport(v *viewport) error {
+	var area *quadtree.Box
+	if v != nil {
+		var err error
+		if area, err = v.area(); err != nil {
+			return err
+		}
+	}
+	e.mu.Lock()
+	e.area = area
+	e.mu.Unlock()
+	return nil
+}
+
+func (e *frameEncoder) viewArea() *quadtree.Box {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.area
+}
+
+func (e *frameEncoder) Encode(v interface{}) (int, []byte, error) {
+	switch v := v.(type) {
+	case []byte:
+		return websocket.TextMessage, v, nil
+	case *encodedFrame:
+		area := e.viewArea()
+		if !e.binary {
+			if area == nil {
+				e.visible = nil
+				data, err := v.JSON()
+				return websocket.TextMessage, data, err
+			}
+			data, err := e.viewFrame(v.Inside(area))
+			return websocket.TextMessage, data, err
+		}
+
+		f := v.frame
+		var data []byte
+		var balls map[int]quantBall
+		if area == nil {
+			data, balls = v.Keyframe()
+		} else {
+			f = v.Inside(area)
+			balls = quantizeFrame(f)
+		}
+		resync := atomic.SwapInt32(&e.resync, 0) == 1
+		switch {
+		case e.sent != nil && e.sinceKey < keyframeInterval && !resync:
+			data = e.delta(f, balls)
+			e.sinceKey++
+		case area != nil:
+			data = encodeBinaryFrame(f, keyframe, f.Balls, balls, nil, nil)
+			e.sinceKey = 0
+		default:
+			e.sinceKey = 0
+		}
+		e.sent = balls
+		return websocket.BinaryMessage, data, nil
+	}
+	return 0, nil, fmt.Errorf("cannot encode %T", v)
+}
+
+// viewFrame encodes for a JSON viewer the balls of a frame inside its
+// viewport, along with the IDs of the balls which entered and left it since
+// the last frame sent. A frame which cannot be encoded, e.g. with a ball at an
+// infinite position, is not sent and changes nothing.
+func (e *frameEncoder) viewFrame(f *game.Frame) ([]byte, error) {
+	visible := make(map[int]bool, len(f.Balls))
+	enter, leave := []int{}, []int{}
+	for _, b := range f.Balls {
+		visible[b.Id] = true
+		if !e.visible[b.Id] {
+			enter = append(enter, b.Id)
+		}
+	}
+	for id := range e.visible {
+		if !visible[id] {
+			leave = append(leave, id)
+		}
+	}
+	sort.Ints(leave)
+
+	data, err := json.Marshal(struct {
+		Type  string          `json:"type"`
+		N     int             `json:"n"`
+		Balls [][]interface{} `json:"balls"`
+		Enter []int           `json:"enter"`
+		Leave []int           `json:"leave"`
+	}{"frame", f.Number, ballArrays(f.Balls), enter, leave})
+	if err != nil {
+		return nil, err
+	}
+	e.visible = visible
+	return data, nil
+}
+
+// delta encodes the changes from the last frame sent to the frame f, whose
+// quantized balls are given.
+func (e *frameEncoder) delta(f *game.Frame, balls map[int]quantBall) []byte {
+	var full []game.BallState
+	var moved []int
+	for _, b := range f.Balls {
+		q := balls[b.Id]
+		p, ok := e.sent[b.Id]
+		switch {
+		case !ok || p.radius != q.radius || p.color != q.color:
+			// new or replaced ball
+			full = append(full, b)
+		case !fitsInt16(int64(q.x)-int64(p.x)) || !fitsInt16(int64(q.y)-int64(p.y)):
+			// too far away for a delta
+			full = append(full, b)
+		case p != q:
+			moved = append(moved, b.Id)
+		}
+	}
+
+	var removed []int
+	for id := range e.sent {
+		if _, ok := balls[id]; !ok {
+			removed = append(removed, id)
+		}
+	}
+	sort.Ints(removed)
+
+	deltas := make([]int16, 0, 3*len(moved))
+	for _, id := range moved {
+		q, p := balls[id], e.sent[id]
+		deltas = append(deltas, int16(q.x-p.x), int16(q.y-p.y), int16(q.angle-p.angle))
+	}
+	return encodeBinaryFrame(f, delta, full, balls, moved, deltas, removed...)
+}
+
+func fitsInt16(v int64) bool {
+	return v >= math.MinInt16 && v <= math.MaxInt16
+}
+
+// serializeBalls encodes a frame as a JSON array of
+// [x, y, radius, colour, angle, id] balls.
+func serializeBalls(f *game.Frame) ([]byte, error) {
+	return json.Marshal(ballArrays(f.Balls))
+}
+
+func ballArrays(balls []game.BallState) [][]interface{} {
+	arrays := make([][]interface{}, len(balls))
+	for i, b := range balls {
+		arrays[i] = []interface{}{b.X, b.Y, b.Radius, b.Color, b.Angle, b.Id}
+	}
+	return arrays
+}
+
+// encodeBinaryFrame encodes a frame in the binary format described along
+// binaryVersion, with the given full balls and for deltas the moved balls
+// quantized deltas and the removed ball IDs.
+func encodeBinaryFrame(f *game.Frame, kind uint8, full []game.BallState, balls map[int]quantBall, moved []int, deltas []int16, removed ...int) []byte {
+	palette := []string{}
+	indexes := make(map[string]uint16)
+	for _, b := range full {
+		if _, ok := indexes[b.Color]; !ok {
+			indexes[b.Color] = uint16(len(palette))
+			palette = append(palette, b.Color)
+		}
+	}
+
+	var buf bytes.Buffer
+	buf.Grow(16 + 8*len(palette) + 20*len(full) + 10*len(moved) + 4*len(removed))
+	write := func(v interface{}) {
+		binary.Write(&buf, binary.LittleEndian, v)
+	}
+
+	write(uint8(binaryVersion))
+	write(kind)
+	write(uint32(f.Number))
+	write(uint16(len(palette)))
+	for _, c := range palette {
+		write(uint8(len(c)))
+		buf.WriteString(c)
+	}
+	write(uint32(len(full)))
+	for _, b := range full {
+		q := balls[b.Id]
+		write(uint32(b.Id))
+		write(q.x)
+		write(q.y)
+		write(q.radius)
+		write(q.angle)
+		write(indexes[b.Color])
+	}
+	if kind == keyframe {
+		return buf.Bytes()
+	}
+
+	write(uint32(len(moved)))
+	for i, id := range moved {
+		write(uint32(id))
+		write(deltas[3*i : 3*i+3])
+	}
+	write(uint32(len(removed)))
+	for _, id := range removed {
+		write(uint32(id))
+	}
+	return buf.Bytes()
+}
@@ -0,0 +1,268 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/adriangonzy/websocket-balls/game"
+	"github.com/gorilla/websocket"
+)
+
+// viewer decodes binary frames the way balls.html does, keeping the balls
+// of the last frame by ID.
+type viewer struct {
+	balls map[int]quantBall
+}
+
+// decode applies a binary frame to the viewer balls and returns its kind.
+func (v *viewer) decode(t *testing.T, data []byte) uint8 {
+	t.Helper()
+	r := bytes.NewReader(data)
+	read := func(x interface{}) {
+		if err := binary.Read(r, binary.LittleEndian, x); err != nil {
+			t.Fatal("Truncated frame:", err)
+		}
+	}
+
+	var version, kind uint8
+	var number uint32
+	read(&version)
+	read(&kind)
+	read(&number)
+	if version != binaryVersion {
+		t.Fatal("Unexpected binary version", version)
+	}
+
+	var paletteLen uint16
+	read(&paletteLen)
+	palette := make([]string, paletteLen)
+	for i := range palette {
+		var n uint8
+		read(&n)
+		c := make([]byte, n)
+		read(c)
+		palette[i] = string(c)
+	}
+
+	if kind == keyframe || v.balls == nil {
+		v.balls = make(map[int]quantBall)
+	}
+	var count uint32
+	read(&count)
+	for i := uint32(0); i < count; i++ {
+		var id uint32
+		var q quantBall
+		var color uint16
+		read(&id)
+		read(&q.x)
+		read(&q.y)
+		read(&q.radius)
+		read(&q.angle)
+		read(&color)
+		q.color = palette[color]
+		v.balls[int(id)] = q
+	}
+	if kind == keyframe {
+		return kind
+	}
+
+	read(&count)
+	for i := uint32(0); i < count; i++ {
+		var id uint32
+		var d [3]int16
+		read(&id)
+		read(&d)
+		q := v.balls[int(id)]
+		q.x += int32(d[0])
+		q.y += int32(d[1])
+		q.angle += uint16(d[2])
+		v.balls[int(id)] = q
+	}
+	read(&count)
+	for i := uint32(0); i < count; i++ {
+		var id uint32
+		read(&id)
+		delete(v.balls, int(id))
+	}
+	if r.Len() != 0 {
+		t.Fatal("Unexpected trailing bytes", r.Len())
+	}
+	return kind
+}
+
+// send encodes a frame for the viewer then decodes it, checking that the
+// viewer ends up with the quantized frame balls.
+func (v *viewer) send(t *testing.T, e *frameEncoder, f *game.Frame) uint8 {
+	t.Helper()
+	mt, data, err := e.Encode(newEncodedFrame(f))
+	if err != nil || mt != websocket.BinaryMessage {
+		t.Fatal("Expected a binary frame, got", mt, err)
+	}
+	kind := v.decode(t, data)
+	want := make(map[int]quantBall, len(f.Balls))
+	for _, b := range f.Balls {
+		want[b.Id] = quantize(b)
+	}
+	if !reflect.DeepEqual(v.balls, want) {
+		t.Fatalf("Frame %d decoded as %v, expected %v", f.Number, v.balls, want)
+	}
+	return kind
+}
+
+func testFrame(n int, balls ...game.BallState) *game.Frame {
+	return &game.Frame{Number: n, Balls: balls}
+}
+
+func TestBinaryKeyframeDeltaRoundTrip(t *testing.T) {
+	e := newFrameEncoder(protoBinary)
+	v := &viewer{}
+
+	frames := []struct {
+		frame *game.Frame
+		kind  uint8
+	}{
+		{testFrame(1,
+			game.BallState{Id: 1, X: 10, Y: 20, Radius: 5, Color: "#f00", Angle: 1},
+			game.BallState{Id: 2, X: 30, Y: 40, Radius: 3, Color: "#0f0"},
+		), keyframe},
+		// moved balls and a new one
+		{testFrame(2,
+			game.BallState{Id: 1, X: 11.5, Y: 19, Radius: 5, Color: "#f00", Angle: 1.5},
+			game.BallState{Id: 2, X: 30, Y: 42, Radius: 3, Color: "#0f0"},
+			game.BallState{Id: 3, X: 50, Y: 50, Radius: 4, Color: "#f00"},
+		), delta},
+		// a removed ball
+		{testFrame(3,
+			game.BallState{Id: 1, X: 12, Y: 18, Radius: 5, Color: "#f00", Angle: 2},
+			game.BallState{Id: 3, X: 50, Y: 50, Radius: 4, Color: "#f00"},
+		), delta},
+		// too far for an int16 delta, sent in full
+		{testFrame(4,
+			game.BallState{Id: 1, X: 9000, Y: 18, Radius: 5, Color: "#f00", Angle: 2},
+			game.BallState{Id: 3, X: 50, Y: 50, Radius: 4, Color: "#00f"},
+		), delta},
+		{testFrame(5), delta},
+	}
+	for _, f := range frames {
+		if kind := v.send(t, e, f.frame); kind != f.kind {
+			t.Errorf("Expected frame %d of kind %d, got %d", f.frame.Number, f.kind, kind)
+		}
+	}
+}
+
+func TestBinaryResyncAndKeyframeInterval(t *testing.T) {
+	e := newFrameEncoder(protoBinary)
+	v := &viewer{}
+	ball := game.BallState{Id: 1, X: 10, Y: 10, Radius: 5, Color: "#f00"}
+
+	v.send(t, e, testFrame(0, ball))
+	for n := 1; n <= keyframeInterval; n++ {
+		ball.X++
+		if kind := v.send(t, e, testFrame(n, ball)); kind != delta {
+			t.Fatalf("Expected frame %d to be a delta", n)
+		}
+	}
+	ball.X++
+	if kind := v.send(t, e, testFrame(keyframeInterval+1, ball)); kind != keyframe {
+		t.Error("Expected a keyframe every", keyframeInterval, "frames")
+	}
+
+	e.Resync()
+	if kind := v.send(t, e, testFrame(keyframeInterval+2, ball)); kind != keyframe {
+		t.Error("Expected a keyframe after a resync")
+	}
+	if kind := v.send(t, e, testFrame(keyframeInterval+3, ball)); kind != delta {
+		t.Error("Expected deltas again after the resync keyframe")
+	}
+}
+
+func TestJSONFrame(t *testing.T) {
+	f := testFrame(1, game.BallState{Id: 3, X: 10, Y: 20, Radius: 5, Color: "#f00", Angle: 1})
+	// peers asking for no subprotocol get JSON
+	for _, proto := range []string{protoJSON, ""} {
+		mt, data, err := newFrameEncoder(proto).Encode(newEncodedFrame(f))
+		if err != nil || mt != websocket.TextMessage {
+			t.Fatal("Expected a text frame, got", mt, err)
+		}
+		var balls [][]interface{}
+		if err := json.Unmarshal(data, &balls); err != nil {
+			t.Fatal(err)
+		}
+		if want := []interface{}{10.0, 20.0, 5.0, "#f00", 1.0, 3.0}; len(balls) != 1 || !reflect.DeepEqual(balls[0], want) {
+			t.Errorf("%q: expected %v, got %v", proto, want, balls)
+		}
+	}
+}
+
+func TestBinaryViewport(t *testing.T) {
+	e := newFrameEncoder(protoBinary)
+	// 100 pixels square, area grown by the margin to -25..125
+	if err := e.SetViewport(&viewport{Width: 100, Height: 100, Zoom: 1}); err != nil {
+		t.Fatal(err)
+	}
+	inside := game.BallState{Id: 1, X: 50, Y: 50, Radius: 5, Color: "#f00"}
+	outside := game.BallState{Id: 2, X: 500, Y: 50, Radius: 5, Color: "#0f0"}
+
+	v := &viewer{}
+	data := func(f *game.Frame) {
+		_, d, err := e.Encode(newEncodedFrame(f))
+		if err != nil {
+			t.Fatal(err)
+		}
+		v.decode(t, d)
+	}
+	data(testFrame(1, inside, outside))
+	if _, ok := v.balls[2]; len(v.balls) != 1 || ok {
+		t.Fatal("Expected only the ball inside the viewport, got", v.balls)
+	}
+
+	// the balls swap sides
+	inside.X, outside.X = 500, 60
+	data(testFrame(2, inside, outside))
+	if _, ok := v.balls[2]; len(v.balls) != 1 || !ok {
+		t.Fatal("Expected the entering ball only, got", v.balls)
+	}
+	if q := quantize(outside); v.balls[2] != q {
+		t.Error("Expected the entering ball in full, got", v.balls[2])
+	}
+}
+
+func TestJSONViewportEnterLeave(t *testing.T) {
+	e := newFrameEncoder(protoJSON)
+	if err := e.SetViewport(&viewport{Width: 100, Height: 100}); err != nil {
+		t.Fatal(err)
+	}
+	type viewFrame struct {
+		N     int             `json:"n"`
+		Balls [][]interface{} `json:"balls"`
+		Enter []int           `json:"enter"`
+		Leave []int           `json:"leave"`
+	}
+	send := func(f *game.Frame) viewFrame {
+		mt, data, err := e.Encode(newEncodedFrame(f))
+		if err != nil || mt != websocket.TextMessage {
+			t.Fatal("Expected a text frame, got", mt, err)
+		}
+		var vf viewFrame
+		if err := json.Unmarshal(data, &vf); err != nil {
+			t.Fatal(err)
+		}
+		return vf
+	}
+
+	a := game.BallState{Id: 1, X: 50, Y: 50, Radius: 5}
+	b := game.BallState{Id: 2, X: 500, Y: 50, Radius: 5}
+	if vf := send(testFrame(1, a, b)); len(vf.Balls) != 1 || !reflect.DeepEqual(vf.Enter, []int{1}) || len(vf.Leave) != 0 {
+		t.Error("Expected ball 1 to enter, got", vf)
+	}
+	a.X, b.X = 500, 50
+	if vf := send(testFrame(2, a, b)); !reflect.DeepEqual(vf.Enter, []int{2}) || !reflect.DeepEqual(vf.Leave, []int{1}) {
+		t.Error("Expected ball 2 to enter and ball 1 to leave, got", vf)
+	}
+	if vf := send(testFrame(3, a, b)); len(vf.Enter) != 0 || len(vf.Leave) != 0 {
+		t.Error("Expected no change, got", vf)
+	}
+}
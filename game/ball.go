@@ -2,17 +2,32 @@ package game
 
 import (
 	"fmt"
+	"math/rand"
 	"time"
 )
 
 type Ball struct {
 	Id     int
 	C      *vector `json:"p"`
-	V      *vector `json:"_"`
+	V      *vector `json:"v"`
 	Radius float64
 	Mass   float64
 	Color  string
-	moved  time.Duration
+	// Restitution is the ratio of normal velocity kept after a bounce, from
+	// 0 (perfectly inelastic) to 1 (perfectly elastic).
+	Restitution float64
+	// Friction is the Coulomb coefficient of the ball surface.
+	Friction float64
+	// Angle is the ball orientation in radians, W its angular velocity in
+	// radians/s.
+	Angle float64
+	W     float64
+
+	moved time.Duration
+	acc   *vector // acceleration at the start of the current frame
+	// acceleration at any state during the current frame, nil without force
+	// fields
+	field Acceleration
 }
 
 func (b *Ball) X() float64 {
@@ -23,27 +38,41 @@ func (b *Ball) Y() float64 {
 	return b.C.Y
 }
 
-func (b *Ball) intersecting(b1 *Ball) bool {
-	return b1.Radius+b.Radius > b1.C.distance(b.C)
+// Inertia is the moment of inertia of the ball, seen as a uniform disc.
+func (b *Ball) Inertia() float64 {
+	return b.Mass * b.Radius * b.Radius / 2
 }
 
 func (b *Ball) String() string {
 	return fmt.Sprintf("%+v", *b)
 }
 
-func (b *Ball) move(delta time.Duration) {
-	// convert to seconds
-	acc := float64(delta/time.Millisecond) / 1000
-	b.C = b.C.add(b.V.multiply(acc))
+// move advances the ball by delta, integrating its motion under the frame
+// acceleration with in.
+func (b *Ball) move(in Integrator, delta time.Duration) {
+	t := delta.Seconds()
+	switch {
+	case b.field != nil:
+		b.C, b.V = in.Step(b.C, b.V, b.field, t)
+	case b.acc != nil:
+		acc := b.acc
+		b.C, b.V = in.Step(b.C, b.V, func(p, v *vector) *vector { return acc }, t)
+	default:
+		b.C = b.C.add(b.V.multiply(t))
+	}
+	b.Angle = b.Angle + b.W*t
 	b.moved = b.moved + delta
 }
 
-func NewRandomBall(c *Config) *Ball {
+func NewRandomBall(c *Config, r *rand.Rand) *Ball {
 	return &Ball{
-		C:      &vector{randFloat(0, c.CanvasWidth/PTM), randFloat(0, c.CanvasHeight/PTM)},
-		V:      &vector{randFloat(c.MinVelocity, c.MaxVelocity), randFloat(c.MinVelocity, c.MaxVelocity)},
-		Radius: randFloat(c.MinRadius, c.MaxRadius),
-		Mass:   randFloat(c.MinMass, c.MaxMass),
-		Color:  randomColor(),
+		C:      &vector{randFloat(r, 0, c.CanvasWidth/PTM), randFloat(r, 0, c.CanvasHeight/PTM)},
+		V:      &vector{randFloat(r, c.MinVelocity, c.MaxVelocity), randFloat(r, c.MinVelocity, c.MaxVelocity)},
+		Radius: randFloat(r, c.MinRadius, c.MaxRadius),
+		Mass:   randFloat(r, c.MinMass, c.MaxMass),
+		Color:  randomColor(r),
+
+		Restitution: c.Restitution,
+		Friction:    c.Friction,
 	}
 }
@@ -0,0 +1,175 @@
+package game
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"regexp"
+)
+
+var errUnknownBall = errors.New("unknown ball")
+
+// errSpawnOverlap is the error of a ball spawned over a live ball or an
+// obstacle, which would stay stuck in it.
+var errSpawnOverlap = ValidationError{{Error: "must not overlap another ball or an obstacle"}}
+
+// colorPattern matches the colors drawn by the viewers: hexadecimal ones, as
+// picked by randomColor, or CSS color names. Colors are sent to the binary
+// viewers prefixed by their length in a byte.
+var colorPattern = regexp.MustCompile(`^(#[0-9a-fA-F]{1,8}|[a-zA-Z]{1,32})$`)
+
+// BallSpec describes a ball to add to a running simulation. Fields left out
+// are drawn at random within the config ranges, like the initial balls.
+type BallSpec struct {
+	X      *float64 `json:"x,omitempty"`      // pixels
+	Y      *float64 `json:"y,omitempty"`      // pixels
+	VX     *float64 `json:"vx,omitempty"`     // meter/s
+	VY     *float64 `json:"vy,omitempty"`     // meter/s
+	Radius *float64 `json:"radius,omitempty"` // meter
+	Mass   *float64 `json:"mass,omitempty"`   // kg
+	Color  string   `json:"color,omitempty"`  // hexadecimal #rrggbb or CSS color name
+}
+
+// validate checks the given fields against the config, returning a
+// ValidationError listing every invalid field otherwise.
+func (sp *BallSpec) validate(c *Config) error {
+	var errs ValidationError
+	inside := func(field string, v *float64, size float64) {
+		if v != nil && !(*v >= 0 && *v <= size) {
+			errs = append(errs, FieldError{field, fmt.Sprintf("must be inside the canvas, between 0 and %g", size)})
+		}
+	}
+	inside("x", sp.X, c.CanvasWidth)
+	inside("y", sp.Y, c.CanvasHeight)
+	if sp.Radius != nil && !(*sp.Radius > 0 && 2**sp.Radius*PTM <= c.CanvasWidth && 2**sp.Radius*PTM <= c.CanvasHeight) {
+		errs = append(errs, FieldError{"radius", "must be positive and fit in the canvas"})
+	}
+	// as fast and as light as the config balls at most, beyond which the
+	// balls leave the world or their velocity diverges under drag
+	speed := func(field string, v *float64) {
+		if v != nil && !(math.Abs(*v) <= c.MaxVelocity) {
+			errs = append(errs, FieldError{field, fmt.Sprintf("must be between %g and %g", -c.MaxVelocity, c.MaxVelocity)})
+		}
+	}
+	speed("vx", sp.VX)
+	speed("vy", sp.VY)
+	if sp.Mass != nil && !(*sp.Mass >= c.MinMass && *sp.Mass <= c.MaxMass) {
+		errs = append(errs, FieldError{"mass", fmt.Sprintf("must be between %g and %g", c.MinMass, c.MaxMass)})
+	}
+	if sp.Color != "" && !colorPattern.MatchString(sp.Color) {
+		errs = append(errs, FieldError{"color", "must be a hexadecimal #rrggbb color or a color name"})
+	}
+	if errs != nil {
+		return errs
+	}
+	return nil
+}
+
+// ball returns the described ball, drawing the missing fields from r. Random
+// values are always drawn so that a spec takes the same numbers from the
+// simulation random source whatever its fields.
+func (sp *BallSpec) ball(c *Config, r *rand.Rand) *Ball {
+	b := NewRandomBall(c, r)
+	if sp.X != nil {
+		b.C.X = *sp.X / PTM
+	}
+	if sp.Y != nil {
+		b.C.Y = *sp.Y / PTM
+	}
+	if sp.VX != nil {
+		b.V.X = *sp.VX
+	}
+	if sp.VY != nil {
+		b.V.Y = *sp.VY
+	}
+	if sp.Radius != nil {
+		b.Radius = *sp.Radius
+	}
+	if sp.Mass != nil {
+		b.Mass = *sp.Mass
+	}
+	if sp.Color != "" {
+		b.Color = sp.Color
+	}
+	return b
+}
+
+// AddBall adds a ball to the simulation between two frames and returns its
+// ID. IDs are never reused, not even the ones of removed balls. The ball must
+// not overlap the live balls nor the obstacles, the coordinates the spec
+// leaves out being drawn until it does not.
+func (s *Simulation) AddBall(sp *BallSpec) (int, error) {
+	type added struct {
+		id  int
+		err error
+	}
+	results := make(chan added, 1)
+	ok := s.do(func() {
+		if err := sp.validate(s.config); err != nil {
+			results <- added{-1, err}
+			return
+		}
+		b := sp.ball(s.config, s.rand)
+		if !s.spawnPosition(sp, b) {
+			results <- added{-1, errSpawnOverlap}
+			return
+		}
+		results <- added{s.addBall(b), nil}
+	})
+	if !ok {
+		return -1, errStopped
+	}
+	r := <-results
+	return r.id, r.err
+}
+
+// spawnPosition moves the ball described by the spec off the live balls and
+// the obstacles, drawing again the coordinates the spec leaves out. It fails
+// when no free position was found.
+func (s *Simulation) spawnPosition(sp *BallSpec, b *Ball) bool {
+	maxRadius := b.Radius
+	for _, other := range s.balls {
+		maxRadius = math.Max(maxRadius, other.Radius)
+	}
+	o := newOccupancy(s.config, maxRadius)
+	for _, other := range s.balls {
+		o.add(other)
+	}
+	return freePosition(s.config, s.rand, b, sp.X != nil, sp.Y != nil, o.free)
+}
+
+// RemoveBall removes the ball with the given ID from the simulation.
+func (s *Simulation) RemoveBall(id int) error {
+	errs := make(chan error, 1)
+	if !s.do(func() { errs <- s.removeBall(id) }) {
+		return errStopped
+	}
+	return <-errs
+}
+
+// Clear removes every ball from the simulation.
+func (s *Simulation) Clear() {
+	s.do(func() {
+		s.balls = nil
+	})
+}
+
+// addBall adds the ball with a new ID and returns it.
+func (s *Simulation) addBall(b *Ball) int {
+	b.Id = s.nextID
+	s.nextID++
+	s.balls = append(s.balls, b)
+	return b.Id
+}
+
+func (s *Simulation) removeBall(id int) error {
+	for i, b := range s.balls {
+		if b.Id == id {
+			// keep the ball order, collisions are computed in it
+			s.balls = append(s.balls[:i], s.balls[i+1:]...)
+			return nil
+		}
+	}
+	return errUnknownBall
+}
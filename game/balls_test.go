@@ -0,0 +1,140 @@
+package game
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestAddRemoveBalls(t *testing.T) {
+	c := testConfig()
+	c.BallCount = 3
+	s := startedTestSimulation(t, c)
+	defer s.Stop()
+
+	x, r := 50.0, 0.8
+	id, err := s.AddBall(&BallSpec{X: &x, Radius: &r, Color: "#abc"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id != 3 {
+		t.Error("Expected ID 3 for the 4th ball, got", id)
+	}
+	if err := s.RemoveBall(id); err != nil {
+		t.Error(err)
+	}
+	if err := s.RemoveBall(id); err == nil {
+		t.Error("Expected an error removing a removed ball")
+	}
+
+	// removed IDs are never reused
+	id, _ = s.AddBall(&BallSpec{})
+	if id != 4 {
+		t.Error("Expected ID 4 after removing ball 3, got", id)
+	}
+	s.Clear()
+	if id, _ = s.AddBall(&BallSpec{}); id != 5 {
+		t.Error("Expected ID 5 after clearing, got", id)
+	}
+
+	sn := s.Snapshot()
+	if len(sn.Balls) != 1 || sn.NextID != 6 {
+		t.Errorf("Expected a single ball and next ID 6, got %d balls and %d", len(sn.Balls), sn.NextID)
+	}
+	restored, err := NewSimulationFromSnapshot(sn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if restored.nextID != 6 {
+		t.Error("Expected next ID restored, got", restored.nextID)
+	}
+}
+
+func TestAddBallSpec(t *testing.T) {
+	c := testConfig()
+	c.BallCount = 0
+	s := startedTestSimulation(t, c)
+	defer s.Stop()
+
+	x, y, vx, m := 20.0, 30.0, -3.0, 1.5
+	id, err := s.AddBall(&BallSpec{X: &x, Y: &y, VX: &vx, Mass: &m, Color: "#f00"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, b := range s.Snapshot().Balls {
+		if b.Id != id {
+			continue
+		}
+		if b.C.X != x/PTM || b.C.Y != y/PTM || b.V.X != vx || b.Mass != m || b.Color != "#f00" {
+			t.Errorf("Ball does not match its spec: %+v", b)
+		}
+		return
+	}
+	t.Error("Added ball not found")
+}
+
+func TestInvalidBallSpec(t *testing.T) {
+	s := startedTestSimulation(t, testConfig())
+	defer s.Stop()
+
+	x, r, m := -1.0, 100.0, 0.0
+	_, err := s.AddBall(&BallSpec{X: &x, Radius: &r, Mass: &m, Color: "#12345678"})
+	if errs, ok := err.(ValidationError); !ok || len(errs) != 3 {
+		t.Errorf("Expected 3 field errors, got %v", err)
+	}
+
+	// faster or lighter than the config balls
+	vx, vy, m := 1e300, -6.0, 0.5
+	_, err = s.AddBall(&BallSpec{VX: &vx, VY: &vy, Mass: &m})
+	if errs, ok := err.(ValidationError); !ok || len(errs) != 3 || errs[0].Field != "vx" || errs[1].Field != "vy" || errs[2].Field != "mass" {
+		t.Errorf("Expected velocity and mass errors, got %v", err)
+	}
+
+	for _, color := range []string{strings.Repeat("a", 300), "#12345g", "red;", "#"} {
+		_, err := s.AddBall(&BallSpec{Color: color})
+		if errs, ok := err.(ValidationError); !ok || len(errs) != 1 || errs[0].Field != "color" {
+			t.Errorf("Expected a color error for %q, got %v", color, err)
+		}
+	}
+}
+
+func TestSpawnOverlap(t *testing.T) {
+	c := testConfig()
+	c.BallCount = 0
+	c.Obstacles = []Obstacle{{Type: Circle, X: 7, Y: 7, Radius: 1}}
+	s := startedTestSimulation(t, c)
+	defer s.Stop()
+
+	// clicking the same spot over and over
+	x, y, r := 30.0, 30.0, 0.5
+	spec := &BallSpec{X: &x, Y: &y, Radius: &r}
+	if _, err := s.AddBall(spec); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 10; i++ {
+		if _, err := s.AddBall(spec); err == nil {
+			t.Fatal("Expected a ball spawned over another one to be rejected")
+		}
+	}
+
+	ox, oy := 70.0, 75.0
+	if _, err := s.AddBall(&BallSpec{X: &ox, Y: &oy, Radius: &r}); err == nil {
+		t.Error("Expected a ball spawned in an obstacle to be rejected")
+	}
+	// the position left out is drawn clear of the balls and the obstacles
+	for i := 0; i < 5; i++ {
+		if _, err := s.AddBall(&BallSpec{X: &x, Radius: &r}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	balls := s.Snapshot().Balls
+	for i, b1 := range balls {
+		if blocked(c, b1.C, b1.Radius) {
+			t.Errorf("Ball %d spawned in the obstacle at %v", b1.Id, b1.C)
+		}
+		for _, b2 := range balls[i+1:] {
+			if b1.C.distance(b2.C) < b1.Radius+b2.Radius {
+				t.Errorf("Balls %d and %d overlap", b1.Id, b2.Id)
+			}
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package game
+
+import "math"
+
+// Canvas boundary modes.
+const (
+	BoundaryReflect = "reflect" // balls bounce off the canvas walls
+	BoundaryWrap    = "wrap"    // balls leaving a side come back from the opposite one
+	BoundaryOpen    = "open"    // balls leaving the canvas are removed, or respawned with Respawn
+)
+
+func validBoundary(boundary string) bool {
+	switch boundary {
+	case "", BoundaryReflect, BoundaryWrap, BoundaryOpen:
+		return true
+	}
+	return false
+}
+
+// images returns the offsets of the copies of the canvas whose balls a ball
+// collides with: the canvas itself, and its neighbours when wrapping around so
+// that balls collide across the seams.
+func (s *Simulation) images() []*vector {
+	offsets := []*vector{{0, 0}}
+	if s.config.Boundary != BoundaryWrap {
+		return offsets
+	}
+	w, h := bounds(s.config)
+	for _, dx := range []float64{-w, 0, w} {
+		for _, dy := range []float64{-h, 0, h} {
+			if dx != 0 || dy != 0 {
+				offsets = append(offsets, &vector{dx, dy})
+			}
+		}
+	}
+	return offsets
+}
+
+// applyBoundary brings back the balls which left the canvas during the frame
+// when wrapping around, or removes them when it is open, respawning new
+// random balls clear of the obstacles in their place with Respawn.
+func (s *Simulation) applyBoundary() {
+	w, h := bounds(s.config)
+	switch s.config.Boundary {
+	case BoundaryWrap:
+		for _, b := range s.balls {
+			b.C = &vector{wrap(b.C.X, w), wrap(b.C.Y, h)}
+		}
+	case BoundaryOpen:
+		kept := s.balls[:0]
+		gone := 0
+		for _, b := range s.balls {
+			if b.C.X+b.Radius < 0 || b.C.X-b.Radius > w || b.C.Y+b.Radius < 0 || b.C.Y-b.Radius > h {
+				gone++
+				continue
+			}
+			kept = append(kept, b)
+		}
+		s.balls = kept
+		if s.config.Respawn {
+			for i := 0; i < gone; i++ {
+				if b := NewRandomBall(s.config, s.rand); clearOfObstacles(s.config, s.rand, b) {
+					s.addBall(b)
+				}
+			}
+		}
+	}
+}
+
+// wrap returns x brought back into [0, size[.
+func wrap(x, size float64) float64 {
+	x = math.Mod(x, size)
+	if x < 0 {
+		x += size
+	}
+	return x
+}
@@ -0,0 +1,88 @@
+package game
+
+import (
+	"testing"
+	"time"
+)
+
+// withBoundary returns a worldTestSimulation option setting the boundary
+// mode.
+func withBoundary(boundary string, respawn bool) func(c *Config) {
+	return func(c *Config) {
+		c.Boundary, c.Respawn = boundary, respawn
+	}
+}
+
+func TestWrapBoundary(t *testing.T) {
+	s := worldTestSimulation(t, withBoundary(BoundaryWrap, false),
+		&Ball{C: &vector{39, 20}, V: &vector{20, 0}, Radius: 1, Mass: 1, Restitution: 1})
+
+	s.run(100 * time.Millisecond)
+	if b := s.balls[0]; b.C.X < 0.9 || b.C.X > 1.1 || b.V.X != 20 {
+		t.Error("Expected the ball to come back from the left side, got", b.C, b.V)
+	}
+}
+
+func TestWrapCollisionAcrossSeam(t *testing.T) {
+	s := worldTestSimulation(t, withBoundary(BoundaryWrap, false),
+		&Ball{C: &vector{38, 20}, V: &vector{10, 0}, Radius: 1, Mass: 1, Restitution: 1},
+		&Ball{C: &vector{2, 20}, V: &vector{-10, 0}, Radius: 1, Mass: 1, Restitution: 1})
+
+	// 2m apart across the seam, touching after 0.1s
+	s.run(200 * time.Millisecond)
+	if b1, b2 := s.balls[0], s.balls[1]; b1.V.X != -10 || b2.V.X != 10 {
+		t.Error("Expected the balls to bounce off each other across the seam, got", b1.V, b2.V)
+	}
+}
+
+func TestOpenBoundary(t *testing.T) {
+	s := worldTestSimulation(t, withBoundary(BoundaryOpen, false),
+		&Ball{C: &vector{39, 20}, V: &vector{30, 0}, Radius: 1, Mass: 1, Restitution: 1},
+		&Ball{C: &vector{20, 20}, V: &vector{0, 0}, Radius: 1, Mass: 1, Restitution: 1})
+
+	s.run(100 * time.Millisecond)
+	if len(s.balls) != 1 || s.balls[0].Id != 1 {
+		t.Fatal("Expected the leaving ball to be removed, got", s.balls)
+	}
+}
+
+func TestOpenBoundaryRespawn(t *testing.T) {
+	s := worldTestSimulation(t, withBoundary(BoundaryOpen, true),
+		&Ball{C: &vector{39, 20}, V: &vector{30, 0}, Radius: 1, Mass: 1, Restitution: 1},
+		&Ball{C: &vector{20, 20}, V: &vector{0, 0}, Radius: 1, Mass: 1, Restitution: 1})
+
+	s.run(100 * time.Millisecond)
+	if len(s.balls) != 2 {
+		t.Fatal("Expected the leaving ball to be respawned, got", s.balls)
+	}
+	if id := s.balls[1].Id; id != 2 {
+		t.Error("Expected the respawned ball to get a new ID, got", id)
+	}
+}
+
+func TestUnknownBoundary(t *testing.T) {
+	c := NewConfig()
+	c.Boundary = "bouncy"
+	errs, ok := c.Validate().(ValidationError)
+	if !ok || len(errs) != 1 || errs[0].Field != "boundary" {
+		t.Error("Expected a boundary error, got", c.Validate())
+	}
+}
+
+func TestOpenBoundaryRespawnClearOfObstacles(t *testing.T) {
+	// all of the canvas but a 2m band at the bottom is an obstacle
+	box := Obstacle{Type: Box, X: 20, Y: 19, Width: 40, Height: 38}
+	s := worldTestSimulation(t, func(c *Config) {
+		c.Boundary, c.Respawn = BoundaryOpen, true
+		c.Obstacles = []Obstacle{box}
+		c.MinRadius, c.MaxRadius = 0.5, 0.5
+	}, &Ball{C: &vector{20, 39}, V: &vector{0, 30}, Radius: 0.5, Mass: 1, Restitution: 1})
+
+	s.run(100 * time.Millisecond)
+	if len(s.balls) != 1 {
+		t.Fatal("Expected the ball to be respawned, got", len(s.balls))
+	}
+	if b := s.balls[0]; box.covers(b.C, b.Radius) {
+		t.Error("Expected the ball to respawn clear of the obstacle, got", b.C)
+	}
+}
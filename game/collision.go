@@ -8,33 +8,60 @@ import (
 
 type Collision struct {
 	B1, B2 *Ball
-	moment time.Duration
+	// immovable surface B1 hits instead of B2, and its index in the world
+	surface surface
+	index   int
+	moment  time.Duration
+	// position of the copy of B2 that B1 hits, relative to B2, when they
+	// collide across a wrap-around seam
+	offset *vector
+	// trajectory changes of B1 and B2 when the collision was predicted, it
+	// does not happen anymore once either ball changed course
+	epoch1, epoch2 int
 }
 
 func (c *Collision) String() string {
 	return fmt.Sprintf("%+v", *c)
 }
 
-func (b *Ball) wallCollision(width, height float64) {
-	r := b.Radius
-	// horizontal movement collision
-	switch {
-	case b.C.X+r >= width/PTM && b.V.X >= 0:
-		b.V.X = -b.V.X
-		b.C.X = width/PTM - r
-	case b.C.X-r <= 0 && b.V.X <= 0:
-		b.V.X = -b.V.X
-		b.C.X = r
-	}
-
-	// vertical movement collision
-	switch {
-	case b.C.Y+r >= height/PTM && b.V.Y >= 0:
-		b.V.Y = -b.V.Y
-		b.C.Y = height/PTM - r
-	case b.C.Y-r <= 0 && b.V.Y <= 0:
-		b.V.Y = -b.V.Y
-		b.C.Y = r
+// wallFriction applies the tangential friction of an immovable surface with
+// unit normal n, pointing from the ball center to the contact point, given
+// the normal impulse jn of the bounce.
+func (b *Ball) wallFriction(n *vector, jn float64) {
+	frictionImpulse(b, nil, n, jn, b.Friction)
+}
+
+// frictionImpulse transfers tangential momentum between b1 and b2 touching at
+// b1 center + b1 radius * n, bounded by the Coulomb friction mu * jn. b2 is
+// nil for an immovable surface. The impulse never does more than stopping the
+// contact points from sliding, spinning the balls into rolling.
+func frictionImpulse(b1, b2 *Ball, n *vector, jn, mu float64) {
+	if mu == 0 || jn == 0 {
+		return
+	}
+	r1 := n.multiply(b1.Radius)
+	tangent := &vector{-n.Y, n.X}
+
+	// contact points relative velocity and tangential effective mass
+	vContact := b1.V.add(cross(b1.W, r1)).multiply(-1)
+	invMass := 1/b1.Mass + b1.Radius*b1.Radius/b1.Inertia()
+	var r2 *vector
+	if b2 != nil {
+		r2 = n.multiply(-b2.Radius)
+		vContact = vContact.add(b2.V.add(cross(b2.W, r2)))
+		invMass += 1/b2.Mass + b2.Radius*b2.Radius/b2.Inertia()
+	}
+
+	jt := -vContact.Dot(tangent) / invMass
+	jt = math.Max(-mu*jn, math.Min(mu*jn, jt))
+
+	// b2 gets the impulse, b1 the opposite one
+	impulse := tangent.multiply(jt)
+	b1.V = b1.V.add(impulse.multiply(-1 / b1.Mass))
+	b1.W -= r1.cross(impulse) / b1.Inertia()
+	if b2 != nil {
+		b2.V = b2.V.add(impulse.multiply(1 / b2.Mass))
+		b2.W += r2.cross(impulse) / b2.Inertia()
 	}
 }
 
@@ -44,12 +71,34 @@ func collisionInFrame(b1, b2 *Ball, frame time.Duration) (*Collision, bool) {
 		return nil, false
 	}
 
-	// avoid molecule like structures (glued balls)
 	V1V2 := &vector{b2.V.X - b1.V.X, b2.V.Y - b1.V.Y}
 	C1C2 := &vector{b2.C.X - b1.C.X, b2.C.Y - b1.C.Y}
 	rTotal := b1.Radius + b2.Radius
 
-	TFrame := float64(frame) / float64(time.Millisecond*1000)
+	// touching balls collide at once when getting closer, and are left to
+	// part otherwise
+	if C1C2.Dot(C1C2) <= rTotal*rTotal {
+		if C1C2.Dot(V1V2) < 0 {
+			return &Collision{B1: b1, B2: b2}, true
+		}
+		return nil, false
+	}
+
+	TFrame := float64(frame) / float64(time.Second)
+
+	// under a relative acceleration the distance is a quartic in time,
+	// solve it numerically instead
+	A1A2 := &vector{0, 0}
+	if b1.acc != nil && b2.acc != nil {
+		A1A2 = b2.acc.sub(b1.acc)
+	}
+	if A1A2.Magnitude() > accelerationEpsilon {
+		t, ok := acceleratedContact(C1C2, V1V2, A1A2, rTotal, TFrame)
+		if !ok {
+			return nil, false
+		}
+		return &Collision{B1: b1, B2: b2, moment: time.Duration(t * float64(time.Second))}, true
+	}
 
 	// discriminant computation
 	a := V1V2.Dot(V1V2)
@@ -66,59 +115,113 @@ func collisionInFrame(b1, b2 *Ball, frame time.Duration) (*Collision, bool) {
 	t1 := (-b + math.Sqrt(D)) / (2 * a)
 	t2 := (-b - math.Sqrt(D)) / (2 * a)
 
-	// the min positive root corresponds to the collision time
-	var t float64
-	switch {
-	case 0 < t1 && 0 < t2:
-		t = math.Min(t1, t2)
-	case 0 < t1 && t2 < 0:
-		if b1.intersecting(b2) && t2 > -TFrame {
-			t = t2
-		}
-		t = t1
-	case 0 < t2 && t1 < 0:
-		if b1.intersecting(b2) && t1 > -TFrame {
-			t = t1
-		}
-		t = t2
+	// apart balls have roots of the same sign, the first positive one
+	// corresponds to the collision time
+	if !(0 < t1 && 0 < t2) {
+		return nil, false
 	}
+	t := math.Min(t1, t2)
 
-	// collision time in ms
-	collisionTime := time.Duration(t*1000) * time.Millisecond
+	collisionTime := time.Duration(t * float64(time.Second))
 
-	if collisionTime > frame || collisionTime < -frame || t == 0 {
+	if collisionTime > frame {
 		return nil, false
 	}
 
 	//* debug
-	if b1.intersecting(b2) {
-		fmt.Println(b1.Id, b2.Id, "intersecting", "t1", t1, "t2", t2)
-	} else {
-		fmt.Println(b1.Id, b2.Id, "t1", t1, "t2", t2)
-	}
+	fmt.Println(b1.Id, b2.Id, "t1", t1, "t2", t2)
 
 	fmt.Println(b1.Id, "collides", b2.Id, "at", collisionTime)
 	//*/ debug
 
-	return &Collision{b1, b2, collisionTime}, true
+	return &Collision{B1: b1, B2: b2, moment: collisionTime}, true
+}
+
+// relative accelerations below this are handled as uniform motion
+const accelerationEpsilon = 1e-9
+
+// acceleratedContact returns the first time in ]0, frame] when two bodies at
+// relative position c, with relative velocity v and constant relative
+// acceleration a, come at distance r.
+func acceleratedContact(c, v, a *vector, r, frame float64) (float64, bool) {
+	gap := func(t float64) float64 {
+		d := c.add(v.multiply(t)).add(a.multiply(t * t / 2))
+		return d.Dot(d) - r*r
+	}
+
+	// already touching, handled by the caller
+	if gap(0) <= 0 {
+		return 0, false
+	}
+
+	// look for the first sign change then bisect it
+	const samples, iterations = 32, 40
+	prev := 0.0
+	for i := 1; i <= samples; i++ {
+		t := frame * float64(i) / samples
+		if gap(t) > 0 {
+			prev = t
+			continue
+		}
+		lo, hi := prev, t
+		for j := 0; j < iterations; j++ {
+			mid := (lo + hi) / 2
+			if gap(mid) > 0 {
+				lo = mid
+			} else {
+				hi = mid
+			}
+		}
+		return hi, true
+	}
+	return 0, false
+}
+
+// other returns the ID of the ball B1 collides with, or a negative one for
+// a surface.
+func (c *Collision) other() int {
+	if c.B2 == nil {
+		return -1 - c.index
+	}
+	return c.B2.Id
 }
 
 func (c *Collision) reaction() {
+	if c.surface != nil {
+		n := c.surface.normal(c.B1)
+		// a ball found sunk into the surface, e.g. pulled in by a force
+		// field while resting on it, is pushed back out before bouncing
+		if depth := c.surface.overlap(c.B1); depth > 0 {
+			c.B1.C = c.B1.C.sub(n.multiply(depth))
+		}
+		c.B1.bounce(n)
+		return
+	}
 	b1, b2 := c.B1, c.B2
 
 	//fmt.Println("COLLISION between", b1.Id, b2.Id)
-	normVector := &vector{b2.C.X - b1.C.X, b2.C.Y - b1.C.Y}
+	normVector := b2.C.sub(b1.C)
+	if c.offset != nil {
+		normVector = normVector.add(c.offset)
+	}
 	normVector.Normalise()
 
 	// balls relative velocity projected on the normal vector
 	vRelative := &vector{b2.V.X - b1.V.X, b2.V.Y - b1.V.Y}
-	vRelative = normVector.multiply(vRelative.Dot(normVector))
+	vNormal := vRelative.Dot(normVector)
+	vRelative = normVector.multiply(vNormal)
 
 	m1, m2 := b1.Mass, b2.Mass
-	massMean := (m1 + m2) / 2
-
-	// v1' = v1 + (m2/massMean) * vRelative
-	b1.V = b1.V.add(vRelative.multiply(m2 / massMean))
-	// v2' = v2 - (m1/massMean) * vRelative
-	b2.V = b2.V.add(vRelative.multiply(-1 * m1 / massMean))
+	// the least bouncy ball sets the energy loss, 1 being perfectly elastic
+	e := math.Min(b1.Restitution, b2.Restitution)
+	k := (1 + e) / (m1 + m2)
+
+	// the roughest surface sets the friction, bounded by the normal impulse
+	jn := k * m1 * m2 * math.Abs(vNormal)
+	frictionImpulse(b1, b2, normVector, jn, math.Min(b1.Friction, b2.Friction))
+
+	// v1' = v1 + (1+e) * m2/(m1+m2) * vRelative
+	b1.V = b1.V.add(vRelative.multiply(k * m2))
+	// v2' = v2 - (1+e) * m1/(m1+m2) * vRelative
+	b2.V = b2.V.add(vRelative.multiply(-k * m1))
 }
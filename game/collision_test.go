@@ -2,32 +2,195 @@ package game
 
 import (
 	"fmt"
+	"math"
 	"testing"
+	"time"
 )
 
 func TestBallCollisionInFrame(t *testing.T) {
 	b1 := &Ball{
-		Id:       1,
-		Position: &vector{10, 10},
-		velocity: &vector{5, 0},
-		Radius:   1,
-		mass:     1,
-		Color:    randomColor(),
+		Id:     1,
+		C:      &vector{10, 10},
+		V:      &vector{5, 0},
+		Radius: 1,
+		Mass:   1,
+		Color:  randomColor(testRand),
 	}
 
 	b2 := &Ball{
-		Id:       2,
-		Position: &vector{22, 10},
-		velocity: &vector{0, 0},
-		Radius:   1,
-		mass:     1,
-		Color:    randomColor(),
+		Id:     2,
+		C:      &vector{22, 10},
+		V:      &vector{0, 0},
+		Radius: 1,
+		Mass:   1,
+		Color:  randomColor(testRand),
 	}
 
-	if c, ok := ballCollisionInFrame(b1, b2, frame); ok {
+	frame := 3 * time.Second
+	if c, ok := collisionInFrame(b1, b2, frame); ok {
 		fmt.Println("collision moment", c.moment)
-		b1.move(c.moment)
-		b2.move(c.moment)
+		b1.move(Verlet{}, c.moment)
+		b2.move(Verlet{}, c.moment)
 	}
 
 }
+
+func momentum(balls ...*Ball) *vector {
+	p := &vector{0, 0}
+	for _, b := range balls {
+		p = p.add(b.V.multiply(b.Mass))
+	}
+	return p
+}
+
+func kineticEnergy(balls ...*Ball) float64 {
+	var e float64
+	for _, b := range balls {
+		e += b.Mass * b.V.Dot(b.V) / 2
+	}
+	return e
+}
+
+func TestInelasticReaction(t *testing.T) {
+	for _, e := range []float64{1, 0.5, 0} {
+		b1 := &Ball{Id: 1, C: &vector{0, 0}, V: &vector{3, 0}, Radius: 1, Mass: 2, Restitution: e}
+		b2 := &Ball{Id: 2, C: &vector{2, 0}, V: &vector{-1, 0}, Radius: 1, Mass: 1, Restitution: 1}
+
+		p, k := momentum(b1, b2), kineticEnergy(b1, b2)
+		(&Collision{B1: b1, B2: b2}).reaction()
+
+		if p1 := momentum(b1, b2); math.Abs(p1.X-p.X) > 1e-9 || math.Abs(p1.Y-p.Y) > 1e-9 {
+			t.Error("Expected momentum", p, "to be conserved, got", p1)
+		}
+		// ΔK = ½ μ (1-e²) vRelative², with μ the reduced mass
+		mu := b1.Mass * b2.Mass / (b1.Mass + b2.Mass)
+		loss := mu * (1 - e*e) * 16 / 2
+		if k1 := kineticEnergy(b1, b2); math.Abs(k-k1-loss) > 1e-9 {
+			t.Error("Expected kinetic energy loss", loss, "for restitution", e, "got", k-k1)
+		}
+		if v := b2.V.X - b1.V.X; math.Abs(v-4*e) > 1e-9 {
+			t.Error("Expected separating velocity", 4*e, "got", v)
+		}
+	}
+}
+
+// the right wall of a 100 pixels wide canvas
+var rightWall = &wall{&vector{1, 0}, 10}
+
+func TestInelasticWallCollision(t *testing.T) {
+	b := &Ball{C: &vector{9.5, 5}, V: &vector{4, 0}, Radius: 1, Mass: 1, Restitution: 0.5}
+	k := kineticEnergy(b)
+	(&Collision{B1: b, surface: rightWall}).reaction()
+	if b.V.X != -2 {
+		t.Error("Expected velocity -2 after bounce, got", b.V.X)
+	}
+	if k1 := kineticEnergy(b); math.Abs(k1-k*0.25) > 1e-9 {
+		t.Error("Expected kinetic energy", k*0.25, "got", k1)
+	}
+}
+
+// angularMomentum about the origin, spin included.
+func angularMomentum(balls ...*Ball) float64 {
+	var l float64
+	for _, b := range balls {
+		l += b.C.cross(b.V.multiply(b.Mass)) + b.Inertia()*b.W
+	}
+	return l
+}
+
+func TestFrictionReaction(t *testing.T) {
+	b1 := &Ball{Id: 1, C: &vector{0, 0}, V: &vector{3, 2}, Radius: 1, Mass: 2, Restitution: 1, Friction: 0.3}
+	b2 := &Ball{Id: 2, C: &vector{2, 0}, V: &vector{-1, -1}, Radius: 1, Mass: 1, Restitution: 1, Friction: 0.5, W: 1}
+
+	p, l := momentum(b1, b2), angularMomentum(b1, b2)
+	(&Collision{B1: b1, B2: b2}).reaction()
+
+	if p1 := momentum(b1, b2); math.Abs(p1.X-p.X) > 1e-9 || math.Abs(p1.Y-p.Y) > 1e-9 {
+		t.Error("Expected momentum", p, "to be conserved, got", p1)
+	}
+	if l1 := angularMomentum(b1, b2); math.Abs(l1-l) > 1e-9 {
+		t.Error("Expected angular momentum", l, "to be conserved, got", l1)
+	}
+	if b1.W == 0 {
+		t.Error("Expected friction to spin the ball")
+	}
+}
+
+func TestWallFriction(t *testing.T) {
+	// grazing the right wall fast enough for a big normal impulse
+	b := &Ball{C: &vector{9.5, 5}, V: &vector{4, 1}, Radius: 1, Mass: 1, Restitution: 1, Friction: 1}
+	(&Collision{B1: b, surface: rightWall}).reaction()
+
+	// enough friction to roll: the contact point does not slide anymore
+	if v := b.V.Y + b.W*b.Radius; math.Abs(v) > 1e-9 {
+		t.Error("Expected the contact point to stop sliding, got", v)
+	}
+
+	b = &Ball{C: &vector{9.5, 5}, V: &vector{4, 1}, Radius: 1, Mass: 1, Restitution: 1}
+	(&Collision{B1: b, surface: rightWall}).reaction()
+	if b.W != 0 || b.V.Y != 1 {
+		t.Error("Expected no spin without friction, got", b.W, b.V)
+	}
+}
+
+func TestNewtonsCradle(t *testing.T) {
+	s := worldTestSimulation(t, nil,
+		&Ball{C: &vector{8, 20}, V: &vector{10, 0}, Radius: 1, Mass: 1, Restitution: 1},
+		&Ball{C: &vector{12, 20}, V: &vector{0, 0}, Radius: 1, Mass: 1, Restitution: 1},
+		&Ball{C: &vector{14, 20}, V: &vector{0, 0}, Radius: 1, Mass: 1, Restitution: 1},
+		&Ball{C: &vector{16, 20}, V: &vector{0, 0}, Radius: 1, Mass: 1, Restitution: 1},
+	)
+
+	// the first ball hits the line at 0.2s
+	s.run(500 * time.Millisecond)
+	for i, v := range []float64{0, 0, 0, 10} {
+		if b := s.balls[i]; math.Abs(b.V.X-v) > 1e-9 {
+			t.Error("Expected ball", i, "velocity", v, "got", b.V)
+		}
+	}
+	if x := s.balls[3].C.X; math.Abs(x-19) > 1e-6 {
+		t.Error("Expected the last ball to move off at 19m, got", x)
+	}
+}
+
+func TestSeveralCollisionsInFrame(t *testing.T) {
+	heavy := &Ball{C: &vector{5, 20}, V: &vector{10, 0}, Radius: 1, Mass: 100, Restitution: 1}
+	s := worldTestSimulation(t, nil,
+		heavy,
+		&Ball{C: &vector{10, 20}, V: &vector{0, 0}, Radius: 1, Mass: 1, Restitution: 1},
+		&Ball{C: &vector{20, 20}, V: &vector{0, 0}, Radius: 1, Mass: 1, Restitution: 1},
+	)
+
+	// within the frame the heavy ball pushes the first light ball, which
+	// hits the second one and stops, then pushes it again
+	p := momentum(s.balls...)
+	s.run(1500 * time.Millisecond)
+	for i, b := range s.balls[1:] {
+		if b.V.X <= heavy.V.X {
+			t.Error("Expected ball", i+1, "to be pushed ahead, got", b.V)
+		}
+	}
+	if p1 := momentum(s.balls...); math.Abs(p1.X-p.X) > 1e-9 {
+		t.Error("Expected momentum", p, "to be conserved, got", p1)
+	}
+}
+
+func TestCollisionEventsLimit(t *testing.T) {
+	// perfectly inelastic balls packed against the wall keep touching
+	var balls []*Ball
+	for i := 0; i < 5; i++ {
+		balls = append(balls, &Ball{C: &vector{39 - 2*float64(i), 20}, V: &vector{10, 0}, Radius: 1, Mass: 1})
+	}
+	s := worldTestSimulation(t, nil, balls...)
+
+	done := make(chan bool)
+	go func() {
+		s.run(time.Second)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected the frame to end")
+	}
+}
@@ -0,0 +1,257 @@
+package game
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+)
+
+type Config struct {
+	CanvasHeight     float64    `json:"canvasHeight"` // pixels
+	CanvasWidth      float64    `json:"canvasWidth"`  // pixels
+	MaxRadius        float64    `json:"maxRadius"`    // meter
+	MinRadius        float64    `json:"minRadius"`    // meter
+	MaxVelocity      float64    `json:"maxVelocity"`  // meter/s
+	MinVelocity      float64    `json:"minVelocity"`  // meter/s
+	MaxMass          float64    `json:"maxMass"`      // kg
+	MinMass          float64    `json:"minMass"`      // kg
+	FrameRate        int        `json:"frameRate"`    // frames/s
+	SearchAreaFactor int        `json:"searchAreaFactor"`
+	BallCount        int        `json:"ballCount"`
+	Placement        string     `json:"placement"`   // initial placement strategy, see PlaceRandom
+	Clusters         int        `json:"clusters"`    // ball clusters of the cluster placement
+	Seed             int64      `json:"seed"`        // random seed, picked from the clock when 0
+	Fields           []Field    `json:"fields"`      // force fields
+	Obstacles        []Obstacle `json:"obstacles"`   // world geometry
+	Boundary         string     `json:"boundary"`    // canvas boundary mode, see BoundaryReflect
+	Respawn          bool       `json:"respawn"`     // respawn the balls leaving an open canvas
+	Integrator       string     `json:"integrator"`  // motion integrator, see IntegratorVerlet
+	Restitution      float64    `json:"restitution"` // default ball restitution, 1 being elastic
+	Friction         float64    `json:"friction"`    // default ball surface friction
+	Substeps         int        `json:"substeps"`    // physics steps per frame
+	MaxCatchUp       int        `json:"maxCatchUp"`  // frames computed at most per tick when late
+	TimeScale        float64    `json:"timeScale"`   // simulated seconds per wall clock second
+	Headless         bool       `json:"headless"`    // compute frames as fast as possible
+
+	Frame time.Duration `json:"-"` // frame duration, derived from FrameRate
+}
+
+var errStopped = errors.New("simulation is stopped")
+
+// MaxFieldStrength bounds the force field accelerations in meter/s² and
+// strengths, beyond which balls cross the canvas within a frame.
+const MaxFieldStrength = 1e4
+
+// MaxFrameRate is the highest frame rate.
+const MaxFrameRate = 1000
+
+// MaxBallCount is the largest number of balls placed at start.
+const MaxBallCount = 10000
+
+// MaxSubsteps is the largest number of physics steps per frame.
+const MaxSubsteps = 100
+
+// NewConfig returns a config with the defaults of every field, to be
+// overridden by the decoded user config.
+func NewConfig() *Config {
+	return &Config{
+		CanvasHeight:     900,
+		CanvasWidth:      900,
+		MaxRadius:        1,
+		MinRadius:        0.1,
+		MaxVelocity:      10,
+		MinVelocity:      0.5,
+		MaxMass:          5,
+		MinMass:          1,
+		FrameRate:        30,
+		SearchAreaFactor: 3,
+		BallCount:        10,
+		Placement:        PlaceRejection,
+		Clusters:         3,
+		Boundary:         BoundaryReflect,
+		Integrator:       IntegratorVerlet,
+		Restitution:      1,
+		Substeps:         1,
+		MaxCatchUp:       5,
+		TimeScale:        1,
+	}
+}
+
+// FieldError is a config field whose value is out of range.
+type FieldError struct {
+	Field string `json:"field,omitempty"` // JSON field name
+	Error string `json:"error"`
+}
+
+// ValidationError lists the invalid fields of a config.
+type ValidationError []FieldError
+
+func (e ValidationError) Error() string {
+	msgs := make([]string, len(e))
+	for i, f := range e {
+		msgs[i] = f.Field + ": " + f.Error
+	}
+	return "invalid config: " + strings.Join(msgs, ", ")
+}
+
+// Validate checks the config values make up a simulation, returning a
+// ValidationError listing every invalid field otherwise. Zero substeps, catch
+// up frames and time scale stand for their defaults.
+func (c *Config) Validate() error {
+	var errs ValidationError
+	fail := func(field, format string, args ...interface{}) {
+		errs = append(errs, FieldError{field, fmt.Sprintf(format, args...)})
+	}
+	positive := func(field string, v float64) bool {
+		if !(v > 0) || math.IsInf(v, 1) {
+			fail(field, "must be a positive number")
+			return false
+		}
+		return true
+	}
+	notNegative := func(field string, v float64) bool {
+		if !(v >= 0) || math.IsInf(v, 1) {
+			fail(field, "must not be negative")
+			return false
+		}
+		return true
+	}
+
+	width, height := positive("canvasWidth", c.CanvasWidth), positive("canvasHeight", c.CanvasHeight)
+	canvas := width && height
+
+	minRadius, maxRadius := positive("minRadius", c.MinRadius), positive("maxRadius", c.MaxRadius)
+	if minRadius && maxRadius {
+		if c.MinRadius > c.MaxRadius {
+			fail("minRadius", "must not be greater than maxRadius")
+		} else if canvas && 2*c.MaxRadius*PTM > math.Min(c.CanvasWidth, c.CanvasHeight) {
+			fail("maxRadius", "balls of %g m do not fit in the canvas", c.MaxRadius)
+		}
+	}
+	minVelocity, maxVelocity := notNegative("minVelocity", c.MinVelocity), notNegative("maxVelocity", c.MaxVelocity)
+	if minVelocity && maxVelocity && c.MinVelocity > c.MaxVelocity {
+		fail("minVelocity", "must not be greater than maxVelocity")
+	}
+	minMass, maxMass := positive("minMass", c.MinMass), positive("maxMass", c.MaxMass)
+	if minMass && maxMass && c.MinMass > c.MaxMass {
+		fail("minMass", "must not be greater than maxMass")
+	}
+
+	if c.FrameRate < 1 || c.FrameRate > MaxFrameRate {
+		fail("frameRate", "must be between 1 and %d", MaxFrameRate)
+	}
+	if c.SearchAreaFactor < 1 {
+		fail("searchAreaFactor", "must be at least 1")
+	}
+	if c.BallCount < 0 || c.BallCount > MaxBallCount {
+		fail("ballCount", "must be between 0 and %d", MaxBallCount)
+	}
+	if _, ok := placers[c.Placement]; !ok {
+		fail("placement", "unknown placement strategy %q", c.Placement)
+	}
+	if c.Clusters < 0 {
+		fail("clusters", "must not be negative")
+	}
+	if !validBoundary(c.Boundary) {
+		fail("boundary", "unknown boundary mode %q", c.Boundary)
+	}
+	if _, ok := integrators[c.Integrator]; !ok {
+		fail("integrator", "unknown integrator %q", c.Integrator)
+	}
+	if !(c.Restitution >= 0 && c.Restitution <= 1) {
+		fail("restitution", "must be between 0 and 1")
+	}
+	notNegative("friction", c.Friction)
+	if c.Substeps < 0 || c.Substeps > MaxSubsteps {
+		fail("substeps", "must be between 0 and %d", MaxSubsteps)
+	}
+	if c.MaxCatchUp < 0 {
+		fail("maxCatchUp", "must not be negative")
+	}
+	if c.TimeScale != 0 && !(c.TimeScale >= MinTimeScale && c.TimeScale <= MaxTimeScale) {
+		fail("timeScale", "must be between %g and %g", MinTimeScale, float64(MaxTimeScale))
+	}
+	for i := range c.Obstacles {
+		if field, err := c.Obstacles[i].validate(); err != nil {
+			fail(fmt.Sprintf("obstacles[%d].%s", i, field), "%v", err)
+		}
+	}
+	for i, f := range c.Fields {
+		field := func(name string) string {
+			return fmt.Sprintf("fields[%d].%s", i, name)
+		}
+		bounded := func(name string, v float64) {
+			if !(math.Abs(v) <= MaxFieldStrength) {
+				fail(field(name), "must be between %g and %g", -MaxFieldStrength, MaxFieldStrength)
+			}
+		}
+		switch f.Type {
+		case Gravity:
+			bounded("x", f.X)
+			bounded("y", f.Y)
+		case Drag:
+			if limit := c.dragLimit(c.MinMass); !(f.Strength >= 0 && f.Strength <= limit) {
+				fail(field("strength"), "must be between 0 and %g for minMass and the physics step", limit)
+			}
+		case Attractor:
+			bounded("strength", f.Strength)
+		default:
+			fail(field("type"), "unknown force field %q", f.Type)
+		}
+	}
+
+	if errs != nil {
+		return errs
+	}
+	return nil
+}
+
+// dragLimit returns the strongest drag a ball of the given mass stands: its
+// velocity would overshoot zero and diverge in a single physics step beyond.
+func (c *Config) dragLimit(mass float64) float64 {
+	limit := MaxFieldStrength
+	if c.FrameRate > 0 && mass > 0 {
+		limit = math.Min(limit, mass*float64(c.FrameRate)*math.Max(1, float64(c.Substeps)))
+	}
+	return limit
+}
+
+// checkDrag makes sure the drag fields do not make a ball of the given mass
+// diverge, for balls lighter than the config ones.
+func (c *Config) checkDrag(mass float64) error {
+	for i, f := range c.Fields {
+		if limit := c.dragLimit(mass); f.Type == Drag && f.Strength > limit {
+			return fmt.Errorf("fields[%d].strength must not be greater than %g for a %g kg ball", i, limit, mass)
+		}
+	}
+	return nil
+}
+
+// copy returns a copy of the config sharing nothing with it.
+func (c *Config) copy() *Config {
+	cc := *c
+	cc.Fields = append([]Field(nil), c.Fields...)
+	cc.Obstacles = append([]Obstacle(nil), c.Obstacles...)
+	return &cc
+}
+
+// prepare fills in the derived and missing config fields.
+func (c *Config) prepare() {
+	c.Frame = time.Second / time.Duration(c.FrameRate)
+	if c.Seed == 0 {
+		// keep the picked seed in the config so the run can be reproduced
+		c.Seed = time.Now().UnixNano()
+	}
+	if c.Substeps < 1 {
+		c.Substeps = 1
+	}
+	if c.MaxCatchUp < 1 {
+		c.MaxCatchUp = 1
+	}
+	c.TimeScale = clampTimeScale(c.TimeScale)
+	if c.Boundary == "" {
+		c.Boundary = BoundaryReflect
+	}
+}
@@ -0,0 +1,136 @@
+package game
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestDefaultConfigIsValid(t *testing.T) {
+	if err := NewConfig().Validate(); err != nil {
+		t.Error(err)
+	}
+	if err := testConfig().Validate(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestValidateConfig(t *testing.T) {
+	c := NewConfig()
+	c.MinRadius = 2
+	c.MaxRadius = 1
+	c.FrameRate = 0
+	c.MinMass = -1
+	c.BallCount = -3
+
+	err := c.Validate()
+	errs, ok := err.(ValidationError)
+	if !ok {
+		t.Fatalf("Expected a validation error, got %v", err)
+	}
+	fields := map[string]bool{}
+	for _, e := range errs {
+		fields[e.Field] = true
+	}
+	for _, f := range []string{"minRadius", "frameRate", "minMass", "ballCount"} {
+		if !fields[f] {
+			t.Errorf("Expected an error for %s in %v", f, errs)
+		}
+	}
+	if len(errs) != 4 {
+		t.Errorf("Expected 4 field errors, got %v", errs)
+	}
+}
+
+func TestValidateRadiusFitsCanvas(t *testing.T) {
+	c := NewConfig()
+	c.CanvasWidth = 50
+	c.MaxRadius = 3 // 60 pixels wide
+	errs, _ := c.Validate().(ValidationError)
+	if len(errs) != 1 || errs[0].Field != "maxRadius" {
+		t.Errorf("Expected a maxRadius error, got %v", errs)
+	}
+}
+
+func TestConfigJSONFields(t *testing.T) {
+	c := NewConfig()
+	if err := json.Unmarshal([]byte(`{"canvasHeight": 300, "frameRate": 60, "ballCount": 5}`), c); err != nil {
+		t.Fatal(err)
+	}
+	if c.CanvasHeight != 300 || c.FrameRate != 60 || c.BallCount != 5 {
+		t.Errorf("Fields not decoded: %+v", c)
+	}
+	if c.CanvasWidth != 900 || c.Restitution != 1 {
+		t.Errorf("Defaults overridden: %+v", c)
+	}
+
+	data, _ := json.Marshal(c)
+	var fields map[string]interface{}
+	json.Unmarshal(data, &fields)
+	if _, ok := fields["searchAreaFactor"]; !ok {
+		t.Errorf("Expected lowercase JSON fields, got %s", data)
+	}
+}
+
+func TestValidateFieldStrengths(t *testing.T) {
+	c := NewConfig()
+	// 30 frames/s and 1kg balls stop in a step under a drag of 30
+	c.Fields = []Field{
+		{Type: Gravity, Y: 9.8},
+		{Type: Drag, Strength: 30},
+		{Type: Attractor, Strength: -100, Falloff: 2},
+	}
+	if err := c.Validate(); err != nil {
+		t.Error("Expected valid fields, got", err)
+	}
+
+	c.Fields = []Field{
+		{Type: Gravity, Y: 2 * MaxFieldStrength},
+		{Type: Drag, Strength: 31},
+		{Type: Drag, Strength: -1},
+		{Type: Attractor, Strength: -2 * MaxFieldStrength},
+	}
+	errs, _ := c.Validate().(ValidationError)
+	want := []string{"fields[0].y", "fields[1].strength", "fields[2].strength", "fields[3].strength"}
+	if len(errs) != len(want) {
+		t.Fatalf("Expected errors for %v, got %v", want, errs)
+	}
+	for i, e := range errs {
+		if e.Field != want[i] {
+			t.Errorf("Expected an error for %s, got %v", want[i], e)
+		}
+	}
+}
+
+func TestValidateWorkload(t *testing.T) {
+	c := NewConfig()
+	c.BallCount = MaxBallCount
+	c.Substeps = MaxSubsteps
+	if err := c.Validate(); err != nil {
+		t.Error("Expected the largest workload to be valid, got", err)
+	}
+
+	c.BallCount = 100000000
+	c.Substeps = 1000000000
+	errs, _ := c.Validate().(ValidationError)
+	if len(errs) != 2 || errs[0].Field != "ballCount" || errs[1].Field != "substeps" {
+		t.Errorf("Expected ballCount and substeps errors, got %v", errs)
+	}
+}
+
+func TestDragLighterBalls(t *testing.T) {
+	c := NewConfig()
+	c.Fields = []Field{{Type: Drag, Strength: 30}}
+	if err := c.checkDrag(c.MinMass); err != nil {
+		t.Error("Expected the drag to hold a config ball, got", err)
+	}
+	if err := c.checkDrag(c.MinMass / 2); err == nil {
+		t.Error("Expected the drag to make a lighter ball diverge")
+	}
+
+	// restoring a ball lighter than the config ones
+	c.prepare()
+	sn := &Snapshot{Config: c, Balls: []*Ball{{C: &vector{1, 1}, V: &vector{1, 1}, Radius: 1, Mass: 0.5, Color: "red"}}}
+	if _, err := NewSimulationFromSnapshot(sn); err == nil {
+		t.Error("Expected an error for a ball too light for the drag")
+	}
+}
@@ -0,0 +1,69 @@
+package game
+
+import (
+	"math"
+)
+
+// Force field types.
+const (
+	Gravity   = "gravity"   // uniform acceleration X, Y in meter/s²
+	Drag      = "drag"      // linear drag force -Strength * velocity
+	Attractor = "attractor" // point at X, Y in meters, repulsor when Strength < 0
+)
+
+// Field is a global force field applied to every ball of the simulation.
+type Field struct {
+	Type     string  `json:"type"`
+	X        float64 `json:"x"`
+	Y        float64 `json:"y"`
+	Strength float64 `json:"strength"`
+	// Attractor force decreases as distance^Falloff, e.g. 2 for an inverse
+	// square law, 0 for a constant pull.
+	Falloff float64 `json:"falloff"`
+}
+
+// acceleration returns the acceleration the field gives to the ball.
+func (f *Field) acceleration(b *Ball) *vector {
+	switch f.Type {
+	case Gravity:
+		return &vector{f.X, f.Y}
+	case Drag:
+		return b.V.multiply(-f.Strength / b.Mass)
+	case Attractor:
+		d := &vector{f.X - b.C.X, f.Y - b.C.Y}
+		// do not let the pull explode when the ball sits on the attractor
+		dist := math.Max(d.Magnitude(), b.Radius)
+		force := f.Strength / math.Pow(dist, f.Falloff)
+		return d.multiply(force / (dist * b.Mass))
+	}
+	return &vector{0, 0}
+}
+
+// applyFields sets the acceleration of every ball for the coming frame.
+// Collisions are predicted with the acceleration at the start of the frame,
+// while the integrators follow its changes along the way.
+func (s *Simulation) applyFields() {
+	fields := s.config.Fields
+	for _, b := range s.balls {
+		b.acc, b.field = &vector{0, 0}, nil
+		if len(fields) == 0 {
+			continue
+		}
+		b.field = fieldsAcceleration(fields, b)
+		b.acc = b.field(b.C, b.V)
+	}
+}
+
+// fieldsAcceleration returns the acceleration the fields give to the ball at
+// any position and velocity.
+func fieldsAcceleration(fields []Field, b *Ball) Acceleration {
+	return func(p, v *vector) *vector {
+		probe := *b
+		probe.C, probe.V = p, v
+		acc := &vector{0, 0}
+		for i := range fields {
+			acc = acc.add(fields[i].acceleration(&probe))
+		}
+		return acc
+	}
+}
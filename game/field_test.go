@@ -0,0 +1,51 @@
+package game
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestGravityField(t *testing.T) {
+	b := &Ball{C: &vector{0, 0}, V: &vector{0, 0}, Radius: 1, Mass: 1}
+	f := &Field{Type: Gravity, Y: 9.8}
+	b.acc = f.acceleration(b)
+	b.move(Verlet{}, time.Second)
+	if math.Abs(b.V.Y-9.8) > 1e-9 || math.Abs(b.C.Y-4.9) > 1e-9 {
+		t.Error("Expected falling ball at y 4.9 with velocity 9.8, got", b.C, b.V)
+	}
+}
+
+func TestDragField(t *testing.T) {
+	b := &Ball{C: &vector{0, 0}, V: &vector{4, 0}, Radius: 1, Mass: 2}
+	f := &Field{Type: Drag, Strength: 1}
+	if a := f.acceleration(b); a.X != -2 || a.Y != 0 {
+		t.Error("Expected acceleration {-2 0}, got", a)
+	}
+}
+
+func TestAttractorField(t *testing.T) {
+	b := &Ball{C: &vector{0, 0}, V: &vector{0, 0}, Radius: 1, Mass: 1}
+	f := &Field{Type: Attractor, X: 10, Strength: 100, Falloff: 2}
+	if a := f.acceleration(b); math.Abs(a.X-1) > 1e-9 || a.Y != 0 {
+		t.Error("Expected acceleration {1 0}, got", a)
+	}
+	f.Strength = -100
+	if a := f.acceleration(b); math.Abs(a.X+1) > 1e-9 {
+		t.Error("Expected repulsion {-1 0}, got", a)
+	}
+}
+
+func TestAcceleratedCollisionInFrame(t *testing.T) {
+	b1 := &Ball{Id: 1, C: &vector{0, 0}, V: &vector{0, 0}, Radius: 1, Mass: 1, acc: &vector{5, 0}}
+	b2 := &Ball{Id: 2, C: &vector{12, 0}, V: &vector{0, 0}, Radius: 1, Mass: 1, acc: &vector{0, 0}}
+
+	// ½ * 5 * t² = 10 meters to contact
+	c, ok := collisionInFrame(b1, b2, 3*time.Second)
+	if !ok {
+		t.Fatal("Expected a collision")
+	}
+	if d := c.moment - 2*time.Second; d < -time.Millisecond || d > time.Millisecond {
+		t.Error("Expected collision at 2s, got", c.moment)
+	}
+}
@@ -0,0 +1,17 @@
+package game
+
+// Frame is what viewers get to draw the simulation after each frame.
+type Frame struct {
+	Number int         `json:"n"`
+	Balls  []BallState `json:"balls"`
+}
+
+// BallState is a ball as drawn on the canvas, in pixels.
+type BallState struct {
+	Id     int     `json:"id"`
+	X      float64 `json:"x"`
+	Y      float64 `json:"y"`
+	Radius float64 `json:"r"`
+	Color  string  `json:"c"`
+	Angle  float64 `json:"a"`
+}
@@ -0,0 +1,66 @@
+package game
+
+// Integrators of the ball motion between collisions.
+const (
+	IntegratorEuler  = "euler"  // semi-implicit Euler, first order
+	IntegratorVerlet = "verlet" // velocity Verlet, second order and exact under constant acceleration
+	IntegratorRK4    = "rk4"    // classic fourth order Runge-Kutta
+)
+
+// Acceleration returns the acceleration of a ball at position p with
+// velocity v.
+type Acceleration func(p, v *vector) *vector
+
+// Integrator advances a ball position and velocity by dt seconds under an
+// acceleration.
+type Integrator interface {
+	Step(p, v *vector, a Acceleration, dt float64) (*vector, *vector)
+}
+
+var integrators = map[string]Integrator{
+	"":               Verlet{},
+	IntegratorEuler:  Euler{},
+	IntegratorVerlet: Verlet{},
+	IntegratorRK4:    RK4{},
+}
+
+// integrator returns the integrator of the config.
+func (s *Simulation) integrator() Integrator {
+	return integrators[s.config.Integrator]
+}
+
+// Euler is the semi-implicit Euler integrator, which updates the velocity
+// first then moves with the new one.
+type Euler struct{}
+
+func (Euler) Step(p, v *vector, a Acceleration, dt float64) (*vector, *vector) {
+	v1 := v.add(a(p, v).multiply(dt))
+	return p.add(v1.multiply(dt)), v1
+}
+
+// Verlet is the velocity Verlet integrator. The velocity the acceleration
+// depends on at the end of the step is predicted with the starting
+// acceleration.
+type Verlet struct{}
+
+func (Verlet) Step(p, v *vector, a Acceleration, dt float64) (*vector, *vector) {
+	a0 := a(p, v)
+	p1 := p.add(v.multiply(dt)).add(a0.multiply(dt * dt / 2))
+	a1 := a(p1, v.add(a0.multiply(dt)))
+	return p1, v.add(a0.add(a1).multiply(dt / 2))
+}
+
+// RK4 is the classic fourth order Runge-Kutta integrator.
+type RK4 struct{}
+
+func (RK4) Step(p, v *vector, a Acceleration, dt float64) (*vector, *vector) {
+	// derivatives of the position and velocity at the four stages
+	p1, v1 := v, a(p, v)
+	p2, v2 := v.add(v1.multiply(dt/2)), a(p.add(p1.multiply(dt/2)), v.add(v1.multiply(dt/2)))
+	p3, v3 := v.add(v2.multiply(dt/2)), a(p.add(p2.multiply(dt/2)), v.add(v2.multiply(dt/2)))
+	p4, v4 := v.add(v3.multiply(dt)), a(p.add(p3.multiply(dt)), v.add(v3.multiply(dt)))
+
+	dp := p1.add(p2.multiply(2)).add(p3.multiply(2)).add(p4).multiply(dt / 6)
+	dv := v1.add(v2.multiply(2)).add(v3.multiply(2)).add(v4).multiply(dt / 6)
+	return p.add(dp), v.add(dv)
+}
@@ -0,0 +1,93 @@
+package game
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+// gravityEnergy is the mechanical energy per kilogram of a body at position p
+// with velocity v under gravity g along Y, in a canvas of the given height.
+func gravityEnergy(p, v *vector, g, height float64) float64 {
+	return v.Dot(v)/2 + g*(height-p.Y)
+}
+
+func TestFreeFallEnergyDrift(t *testing.T) {
+	const g, dt, steps = 9.8, 0.01, 1000
+	gravity := func(p, v *vector) *vector { return &vector{0, g} }
+
+	drifts := make(map[string]float64)
+	for _, name := range []string{IntegratorEuler, IntegratorVerlet, IntegratorRK4} {
+		in := integrators[name]
+		p, v := &vector{0, 0}, &vector{3, -20}
+		e := gravityEnergy(p, v, g, 0)
+		for i := 0; i < steps; i++ {
+			p, v = in.Step(p, v, gravity, dt)
+		}
+		drifts[name] = math.Abs(gravityEnergy(p, v, g, 0)-e) / e
+		t.Logf("%s energy drift %.3g", name, drifts[name])
+	}
+
+	if drifts[IntegratorVerlet] > 1e-9 || drifts[IntegratorRK4] > 1e-9 {
+		t.Error("Expected no energy drift under constant acceleration, got", drifts)
+	}
+	if drifts[IntegratorEuler] < 1e-3 {
+		t.Error("Expected the first order Euler integrator to drift, got", drifts)
+	}
+}
+
+func TestBouncingEnergyDrift(t *testing.T) {
+	const g = 9.8
+	for _, name := range []string{IntegratorEuler, IntegratorVerlet, IntegratorRK4} {
+		// bouncing off the floor and both side walls
+		s := worldTestSimulation(t, func(c *Config) {
+			c.Integrator = name
+			c.Fields = []Field{{Type: Gravity, Y: g}}
+		}, &Ball{C: &vector{20, 10}, V: &vector{7, 0}, Radius: 1, Mass: 1, Restitution: 1})
+		b := s.balls[0]
+		e := gravityEnergy(b.C, b.V, g, 40)
+
+		// 10 seconds, several bounces
+		for i := 0; i < 1000; i++ {
+			s.run(10 * time.Millisecond)
+		}
+		drift := math.Abs(gravityEnergy(b.C, b.V, g, 40)-e) / e
+		t.Logf("%s energy drift %.3g", name, drift)
+
+		limit := 1e-6
+		if name == IntegratorEuler {
+			limit = 0.05
+		}
+		if drift > limit {
+			t.Errorf("Expected %s energy drift below %g, got %g", name, limit, drift)
+		}
+	}
+}
+
+func TestDragIntegratorsOrder(t *testing.T) {
+	// v' = -v, exactly v0 * e^-t
+	const dt, steps = 0.1, 10
+	drag := func(p, v *vector) *vector { return v.multiply(-1) }
+	exact := 10 * math.Exp(-dt*steps)
+
+	errs := make(map[string]float64)
+	for _, name := range []string{IntegratorEuler, IntegratorVerlet, IntegratorRK4} {
+		p, v := &vector{0, 0}, &vector{10, 0}
+		for i := 0; i < steps; i++ {
+			p, v = integrators[name].Step(p, v, drag, dt)
+		}
+		errs[name] = math.Abs(v.X - exact)
+	}
+	if !(errs[IntegratorRK4] < errs[IntegratorVerlet] && errs[IntegratorVerlet] < errs[IntegratorEuler]) {
+		t.Error("Expected higher order integrators to be more accurate, got", errs)
+	}
+}
+
+func TestUnknownIntegrator(t *testing.T) {
+	c := NewConfig()
+	c.Integrator = "leapfrog"
+	errs, ok := c.Validate().(ValidationError)
+	if !ok || len(errs) != 1 || errs[0].Field != "integrator" {
+		t.Error("Expected an integrator error, got", c.Validate())
+	}
+}
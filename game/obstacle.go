@@ -0,0 +1,338 @@
+package game
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+// Obstacle types.
+const (
+	Segment = "segment" // line from X1, Y1 to X2, Y2
+	Box     = "box"     // Width by Height rectangle centered on X, Y, turned by Angle
+	Circle  = "circle"  // disc of Radius centered on X, Y
+)
+
+// Obstacle is an immovable piece of the world geometry. Balls bounce off it
+// with their own restitution and friction, as off the canvas walls. Positions
+// and lengths are in meters, angles in radians.
+type Obstacle struct {
+	Type   string  `json:"type"`
+	X1     float64 `json:"x1,omitempty"`
+	Y1     float64 `json:"y1,omitempty"`
+	X2     float64 `json:"x2,omitempty"`
+	Y2     float64 `json:"y2,omitempty"`
+	X      float64 `json:"x,omitempty"`
+	Y      float64 `json:"y,omitempty"`
+	Width  float64 `json:"width,omitempty"`
+	Height float64 `json:"height,omitempty"`
+	Angle  float64 `json:"angle,omitempty"`
+	Radius float64 `json:"radius,omitempty"`
+}
+
+// validate returns an error about the first invalid obstacle field.
+func (o *Obstacle) validate() (field string, err error) {
+	switch o.Type {
+	case Segment:
+		if o.X1 == o.X2 && o.Y1 == o.Y2 {
+			return "x2", fmt.Errorf("segment ends must differ")
+		}
+	case Box:
+		if !(o.Width > 0) {
+			return "width", fmt.Errorf("must be a positive number")
+		}
+		if !(o.Height > 0) {
+			return "height", fmt.Errorf("must be a positive number")
+		}
+	case Circle:
+		if !(o.Radius > 0) {
+			return "radius", fmt.Errorf("must be a positive number")
+		}
+	default:
+		return "type", fmt.Errorf("unknown obstacle %q", o.Type)
+	}
+	return "", nil
+}
+
+// Pixels returns the obstacle with its positions and lengths in pixels, as
+// drawn by the viewers.
+func (o Obstacle) Pixels() Obstacle {
+	o.X1, o.Y1, o.X2, o.Y2 = o.X1*PTM, o.Y1*PTM, o.X2*PTM, o.Y2*PTM
+	o.X, o.Y = o.X*PTM, o.Y*PTM
+	o.Width, o.Height, o.Radius = o.Width*PTM, o.Height*PTM, o.Radius*PTM
+	return o
+}
+
+// surfaces breaks the obstacle down into the lines and points the balls
+// collide with.
+func (o *Obstacle) surfaces() []surface {
+	switch o.Type {
+	case Segment:
+		a, b := &vector{o.X1, o.Y1}, &vector{o.X2, o.Y2}
+		return []surface{newLine(a, b), &point{a, 0}, &point{b, 0}}
+	case Box:
+		cos, sin := math.Cos(o.Angle), math.Sin(o.Angle)
+		corner := func(dx, dy float64) *vector {
+			return &vector{o.X + dx*cos - dy*sin, o.Y + dx*sin + dy*cos}
+		}
+		w, h := o.Width/2, o.Height/2
+		corners := []*vector{corner(-w, -h), corner(w, -h), corner(w, h), corner(-w, h)}
+		var surfaces []surface
+		for i, c := range corners {
+			surfaces = append(surfaces, newLine(c, corners[(i+1)%4]), &point{c, 0})
+		}
+		return surfaces
+	case Circle:
+		return []surface{&point{&vector{o.X, o.Y}, o.Radius}}
+	}
+	return nil
+}
+
+// covers reports whether a ball of the given radius at p would overlap the
+// obstacle, or lie inside a box or circle.
+func (o *Obstacle) covers(p *vector, radius float64) bool {
+	switch o.Type {
+	case Segment:
+		a := &vector{o.X1, o.Y1}
+		l := newLine(a, &vector{o.X2, o.Y2})
+		along := math.Max(0, math.Min(l.length, l.u.Dot(p.sub(a))))
+		return p.distance(a.add(l.u.multiply(along))) < radius
+	case Box:
+		// p in the box frame, then the nearest point of the box
+		d := p.sub(&vector{o.X, o.Y})
+		cos, sin := math.Cos(o.Angle), math.Sin(o.Angle)
+		local := &vector{d.X*cos + d.Y*sin, d.Y*cos - d.X*sin}
+		w, h := o.Width/2, o.Height/2
+		nearest := &vector{math.Max(-w, math.Min(w, local.X)), math.Max(-h, math.Min(h, local.Y))}
+		return local.distance(nearest) < radius
+	case Circle:
+		return p.distance(&vector{o.X, o.Y}) < o.Radius+radius
+	}
+	return false
+}
+
+// buildWorld returns the canvas walls, unless balls may leave the canvas,
+// followed by the surfaces of every obstacle.
+func buildWorld(c *Config) []surface {
+	var world []surface
+	if c.Boundary == BoundaryReflect || c.Boundary == "" {
+		w, h := bounds(c)
+		world = []surface{
+			&wall{&vector{-1, 0}, 0},
+			&wall{&vector{1, 0}, w},
+			&wall{&vector{0, -1}, 0},
+			&wall{&vector{0, 1}, h},
+		}
+	}
+	for i := range c.Obstacles {
+		world = append(world, c.Obstacles[i].surfaces()...)
+	}
+	return world
+}
+
+// surface is an immovable line or point, possibly with a radius.
+type surface interface {
+	// contact returns the first time in [0, frame] seconds when the ball
+	// moving with its velocity and acceleration touches the surface. A ball
+	// already overlapping the surface and moving further in touches it at 0.
+	contact(b *Ball, frame float64) (float64, bool)
+	// normal returns the unit vector from the ball center to the surface.
+	normal(b *Ball) *vector
+	// near reports whether the ball may reach the surface within the given
+	// distance.
+	near(b *Ball, dist float64) bool
+	// overlap returns how deep the ball sinks into the surface, 0 or less
+	// when apart.
+	overlap(b *Ball) float64
+}
+
+// wall is a canvas side, the balls staying where n.x <= d.
+type wall struct {
+	n *vector
+	d float64
+}
+
+func (w *wall) contact(b *Ball, frame float64) (float64, bool) {
+	gap := w.d - w.n.Dot(b.C) - b.Radius
+	if gap <= 0 {
+		return 0, w.n.Dot(b.V) > 0
+	}
+	return firstRoot(gap, -w.n.Dot(b.V), -w.n.Dot(ballAcc(b))/2, frame)
+}
+
+func (w *wall) normal(b *Ball) *vector {
+	return w.n
+}
+
+func (w *wall) near(b *Ball, dist float64) bool {
+	return w.d-w.n.Dot(b.C)-b.Radius <= dist
+}
+
+func (w *wall) overlap(b *Ball) float64 {
+	return w.n.Dot(b.C) + b.Radius - w.d
+}
+
+// line is the straight part of a segment, from a to a + length * u.
+type line struct {
+	a, u, n *vector
+	length  float64
+}
+
+func newLine(a, b *vector) *line {
+	d := b.sub(a)
+	length := d.Magnitude()
+	u := d.multiply(1 / length)
+	return &line{a: a, u: u, n: &vector{-u.Y, u.X}, length: length}
+}
+
+func (l *line) contact(b *Ball, frame float64) (float64, bool) {
+	// signed distance to the line, a quadratic in time
+	d := l.n.Dot(b.C.sub(l.a))
+	side := 1.0
+	if d < 0 {
+		side = -1
+	}
+	acc := ballAcc(b)
+	gap := side*d - b.Radius
+	speed := side * l.n.Dot(b.V)
+	along := func(t float64) bool {
+		c := b.C.add(b.V.multiply(t)).add(acc.multiply(t * t / 2))
+		p := l.u.Dot(c.sub(l.a))
+		return p >= 0 && p <= l.length
+	}
+
+	if gap <= 0 {
+		return 0, speed < 0 && along(0)
+	}
+	t, ok := firstRoot(gap, speed, side*l.n.Dot(acc)/2, frame)
+	return t, ok && along(t)
+}
+
+func (l *line) normal(b *Ball) *vector {
+	if l.n.Dot(b.C.sub(l.a)) < 0 {
+		return l.n
+	}
+	return l.n.multiply(-1)
+}
+
+func (l *line) near(b *Ball, dist float64) bool {
+	// distance to the segment
+	p := math.Max(0, math.Min(l.length, l.u.Dot(b.C.sub(l.a))))
+	return b.C.distance(l.a.add(l.u.multiply(p))) <= dist+b.Radius
+}
+
+func (l *line) overlap(b *Ball) float64 {
+	return b.Radius - math.Abs(l.n.Dot(b.C.sub(l.a)))
+}
+
+// point is a segment end or box corner, or a fixed circle with a radius.
+type point struct {
+	p      *vector
+	radius float64
+}
+
+func (p *point) contact(b *Ball, frame float64) (float64, bool) {
+	// relative position and velocity of the point seen from the ball
+	c := p.p.sub(b.C)
+	v := b.V.multiply(-1)
+	a := ballAcc(b).multiply(-1)
+	r := p.radius + b.Radius
+
+	if c.Dot(c) <= r*r {
+		return 0, c.Dot(v) < 0
+	}
+	if a.Magnitude() > accelerationEpsilon {
+		return acceleratedContact(c, v, a, r, frame)
+	}
+	return firstRoot(c.Dot(c)-r*r, 2*c.Dot(v), v.Dot(v), frame)
+}
+
+func (p *point) normal(b *Ball) *vector {
+	d := p.p.sub(b.C)
+	if d.Magnitude() == 0 {
+		// no way to tell, do not bounce
+		return d
+	}
+	return d.Normalise()
+}
+
+func (p *point) near(b *Ball, dist float64) bool {
+	return b.C.distance(p.p) <= dist+b.Radius+p.radius
+}
+
+func (p *point) overlap(b *Ball) float64 {
+	return b.Radius + p.radius - b.C.distance(p.p)
+}
+
+func ballAcc(b *Ball) *vector {
+	if b.acc == nil {
+		return &vector{0, 0}
+	}
+	return b.acc
+}
+
+// reach returns the farthest the ball can go within the given seconds.
+func reach(b *Ball, seconds float64) float64 {
+	return b.V.Magnitude()*seconds + ballAcc(b).Magnitude()*seconds*seconds/2
+}
+
+// firstRoot returns the first root in ]0, max] of c0 + c1*t + c2*t², given
+// c0 > 0.
+func firstRoot(c0, c1, c2, max float64) (float64, bool) {
+	var roots []float64
+	if math.Abs(c2) < accelerationEpsilon {
+		if c1 < 0 {
+			roots = append(roots, -c0/c1)
+		}
+	} else {
+		D := c1*c1 - 4*c2*c0
+		if D < 0 {
+			return 0, false
+		}
+		t1 := (-c1 - math.Sqrt(D)) / (2 * c2)
+		t2 := (-c1 + math.Sqrt(D)) / (2 * c2)
+		roots = append(roots, math.Min(t1, t2), math.Max(t1, t2))
+	}
+	for _, t := range roots {
+		if t > 0 && t <= max {
+			return t, true
+		}
+	}
+	return 0, false
+}
+
+// obstacleCollisions returns the contacts of the ball with the world
+// surfaces within the frame.
+func (s *Simulation) obstacleCollisions(b *Ball, frame time.Duration) []*Collision {
+	seconds := float64(frame) / float64(time.Second)
+	reach := reach(b, seconds)
+
+	var collisions []*Collision
+	for i, w := range s.world {
+		if !w.near(b, reach) {
+			continue
+		}
+		if t, ok := w.contact(b, seconds); ok {
+			collisions = append(collisions, &Collision{
+				B1:      b,
+				surface: w,
+				index:   i,
+				moment:  time.Duration(t * float64(time.Second)),
+			})
+		}
+	}
+	return collisions
+}
+
+// bounce reflects the ball off the surface it touches, losing the normal
+// velocity its restitution does not keep and spinning by its friction.
+func (b *Ball) bounce(n *vector) {
+	vn := b.V.Dot(n)
+	if vn <= 0 {
+		// moving away already
+		return
+	}
+	jn := (1 + b.Restitution) * b.Mass * vn
+	b.wallFriction(n, jn)
+	b.V = b.V.add(n.multiply(-(1 + b.Restitution) * vn))
+}
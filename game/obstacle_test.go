@@ -0,0 +1,138 @@
+package game
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+// withObstacles returns a worldTestSimulation option setting the obstacles.
+func withObstacles(obstacles ...Obstacle) func(c *Config) {
+	return func(c *Config) {
+		c.Obstacles = obstacles
+	}
+}
+
+func TestSegmentBounce(t *testing.T) {
+	// horizontal segment under a falling ball
+	s := worldTestSimulation(t, withObstacles(Obstacle{Type: Segment, X1: 10, Y1: 20, X2: 30, Y2: 20}),
+		&Ball{C: &vector{20, 18}, V: &vector{0, 30}, Radius: 1, Mass: 1, Restitution: 1})
+
+	s.run(100 * time.Millisecond)
+	b := s.balls[0]
+	if b.V.Y >= 0 {
+		t.Error("Expected the ball to bounce up, got velocity", b.V)
+	}
+	if b.C.Y > 19 {
+		t.Error("Expected the ball to stay above the segment, got", b.C)
+	}
+}
+
+func TestSegmentEndMiss(t *testing.T) {
+	// falling past the segment end
+	s := worldTestSimulation(t, withObstacles(Obstacle{Type: Segment, X1: 10, Y1: 20, X2: 30, Y2: 20}),
+		&Ball{C: &vector{35, 18}, V: &vector{0, 30}, Radius: 1, Mass: 1, Restitution: 1})
+
+	s.run(100 * time.Millisecond)
+	if s.balls[0].V.Y <= 0 {
+		t.Error("Expected the ball to miss the segment, got velocity", s.balls[0].V)
+	}
+}
+
+func TestCircleBounce(t *testing.T) {
+	s := worldTestSimulation(t, withObstacles(Obstacle{Type: Circle, X: 20, Y: 20, Radius: 2}),
+		&Ball{C: &vector{10, 20}, V: &vector{50, 0}, Radius: 1, Mass: 1, Restitution: 1})
+
+	s.run(200 * time.Millisecond)
+	b := s.balls[0]
+	if b.V.X >= 0 {
+		t.Error("Expected the ball to bounce back, got velocity", b.V)
+	}
+	if b.C.distance(&vector{20, 20}) < 3-1e-9 {
+		t.Error("Expected the ball outside the circle, got", b.C)
+	}
+}
+
+func TestRotatedBoxCorner(t *testing.T) {
+	// diamond whose left corner is at 18, 20
+	s := worldTestSimulation(t, withObstacles(Obstacle{Type: Box, X: 20, Y: 20, Width: 2 * math.Sqrt2, Height: 2 * math.Sqrt2, Angle: math.Pi / 4}),
+		&Ball{C: &vector{10, 20}, V: &vector{50, 0}, Radius: 1, Mass: 1, Restitution: 1})
+
+	s.run(200 * time.Millisecond)
+	b := s.balls[0]
+	if math.Abs(b.V.X+50) > 1e-6 || math.Abs(b.V.Y) > 1e-6 {
+		t.Error("Expected a head-on bounce off the corner, got velocity", b.V)
+	}
+}
+
+func TestInvalidObstacle(t *testing.T) {
+	c := testConfig()
+	c.Obstacles = []Obstacle{{Type: Box, Width: 1}, {Type: "triangle"}}
+	errs, _ := c.Validate().(ValidationError)
+	if len(errs) != 2 || errs[0].Field != "obstacles[0].height" || errs[1].Field != "obstacles[1].type" {
+		t.Errorf("Expected obstacle errors, got %v", errs)
+	}
+}
+
+func TestWallContactTime(t *testing.T) {
+	b := &Ball{C: &vector{5, 5}, V: &vector{10, 0}, Radius: 1}
+	if at, ok := rightWall.contact(b, 1); !ok || math.Abs(at-0.4) > 1e-9 {
+		t.Error("Expected the ball to touch the wall at 0.4s, got", at, ok)
+	}
+	b.C.X = 9.5
+	if at, ok := rightWall.contact(b, 1); !ok || at != 0 {
+		t.Error("Expected an overlapping ball to touch the wall at once, got", at, ok)
+	}
+	b.V.X = -10
+	if _, ok := rightWall.contact(b, 1); ok {
+		t.Error("Expected no contact while leaving the wall")
+	}
+}
+
+func TestFastBallStaysInCanvas(t *testing.T) {
+	// moves 30m in the frame, bouncing off the right wall of the 40m canvas
+	s := worldTestSimulation(t, nil,
+		&Ball{C: &vector{20, 20}, V: &vector{150, 0}, Radius: 1, Mass: 1, Restitution: 1})
+
+	s.run(200 * time.Millisecond)
+	b := s.balls[0]
+	if b.C.X < 1-1e-9 || b.C.X > 39+1e-9 {
+		t.Error("Expected the ball inside the canvas, got", b.C)
+	}
+	// 19m to the right wall, then 11m back
+	if math.Abs(b.C.X-28) > 1e-6 || b.V.X != -150 {
+		t.Error("Expected the ball back at 28m moving left, got", b.C, b.V)
+	}
+}
+
+func TestWallThenBall(t *testing.T) {
+	// bounces off the right wall into a ball it was moving away from
+	s := worldTestSimulation(t, nil,
+		&Ball{C: &vector{36, 20}, V: &vector{20, 0}, Radius: 1, Mass: 1, Restitution: 1},
+		&Ball{C: &vector{30, 20}, V: &vector{0, 0}, Radius: 1, Mass: 1, Restitution: 1})
+
+	s.run(600 * time.Millisecond)
+	if b := s.balls[0]; b.V.X != 0 {
+		t.Error("Expected the first ball to stop in the collision, got", b.V)
+	}
+	if b := s.balls[1]; b.V.X != -20 {
+		t.Error("Expected the second ball to move off, got", b.V)
+	}
+}
+
+func TestRestingOnFloor(t *testing.T) {
+	for _, e := range []float64{0, 0.5, 1} {
+		// a meter above the floor, the floor contact being at 39m
+		s := worldTestSimulation(t, func(c *Config) {
+			c.Fields = []Field{{Type: Gravity, Y: 9.8}}
+		}, &Ball{C: &vector{20, 38}, V: &vector{0, 0}, Radius: 1, Mass: 1, Restitution: e})
+
+		for i := 0; i < 3000; i++ {
+			s.run(33 * time.Millisecond)
+		}
+		// sinking at most the distance fallen within a frame
+		if y := s.balls[0].C.Y; y > 39+9.8*0.033*0.033/2+1e-9 {
+			t.Errorf("Expected the ball with restitution %g to rest on the floor, got y %g", e, y)
+		}
+	}
+}
@@ -0,0 +1,359 @@
+package game
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+)
+
+// Initial ball placement strategies.
+const (
+	PlaceRandom    = "random"    // anywhere, overlapping balls included
+	PlaceRejection = "rejection" // random positions, drawn again while overlapping
+	PlacePoisson   = "poisson"   // Poisson-disk sampling, evenly spread at random
+	PlaceGrid      = "grid"      // square lattice spread over the canvas
+	PlaceHex       = "hex"       // hexagonal packing spread over the canvas
+	PlaceCluster   = "cluster"   // gathered around Clusters random centers
+)
+
+// placementAttempts is the number of positions drawn for a ball before giving
+// up on finding it a free spot.
+const placementAttempts = 1000
+
+// placer positions the balls of a new simulation, whose other properties are
+// already drawn, without overlapping unless stated otherwise. It fails when
+// the balls cannot fit in the canvas.
+type placer interface {
+	place(c *Config, r *rand.Rand, balls []*Ball) error
+}
+
+var placers = map[string]placer{
+	"":             randomPlacer{},
+	PlaceRandom:    randomPlacer{},
+	PlaceRejection: rejectionPlacer{},
+	PlacePoisson:   poissonPlacer{},
+	PlaceGrid:      latticePlacer{hex: false},
+	PlaceHex:       latticePlacer{hex: true},
+	PlaceCluster:   clusterPlacer{},
+}
+
+// placeBalls positions the balls with the config placement strategy, clear
+// of the obstacles.
+func placeBalls(c *Config, r *rand.Rand, balls []*Ball) error {
+	if err := placers[c.Placement].place(c, r, balls); err != nil {
+		return ValidationError{{"ballCount", err.Error()}}
+	}
+	return nil
+}
+
+func errNoFit(c *Config, n int) error {
+	return fmt.Errorf("%d balls do not fit in the canvas with %s placement", n, c.Placement)
+}
+
+// bounds returns the canvas size in meters.
+func bounds(c *Config) (float64, float64) {
+	return c.CanvasWidth / PTM, c.CanvasHeight / PTM
+}
+
+// blocked reports whether a ball of the given radius at p would overlap an
+// obstacle.
+func blocked(c *Config, p *vector, radius float64) bool {
+	for i := range c.Obstacles {
+		if c.Obstacles[i].covers(p, radius) {
+			return true
+		}
+	}
+	return false
+}
+
+// clearOfObstacles draws the ball position again, the way NewRandomBall does,
+// while it overlaps an obstacle. It fails when no free position was found.
+func clearOfObstacles(c *Config, r *rand.Rand, b *Ball) bool {
+	return freePosition(c, r, b, false, false, func(p *vector, radius float64) bool {
+		return !blocked(c, p, radius)
+	})
+}
+
+// freePosition draws the ball coordinates which are not fixed again, the way
+// NewRandomBall does, while free reports the ball position taken. It fails
+// when no free position was found.
+func freePosition(c *Config, r *rand.Rand, b *Ball, fixedX, fixedY bool, free func(p *vector, radius float64) bool) bool {
+	w, h := bounds(c)
+	for i := 0; i < placementAttempts; i++ {
+		if free(b.C, b.Radius) {
+			return true
+		}
+		if fixedX && fixedY {
+			return false
+		}
+		p := &vector{b.C.X, b.C.Y}
+		if !fixedX {
+			p.X = randFloat(r, 0, w)
+		}
+		if !fixedY {
+			p.Y = randFloat(r, 0, h)
+		}
+		b.C = p
+	}
+	return false
+}
+
+// randomPlacer keeps the positions drawn by NewRandomBall, unless they
+// overlap an obstacle.
+type randomPlacer struct{}
+
+func (randomPlacer) place(c *Config, r *rand.Rand, balls []*Ball) error {
+	for _, b := range balls {
+		if !clearOfObstacles(c, r, b) {
+			return errNoFit(c, len(balls))
+		}
+	}
+	return nil
+}
+
+// occupancy is a grid of the placed balls, whose cells are at least as large
+// as the largest ball so that overlaps are only looked for in the
+// neighbouring cells. The obstacles are always occupied.
+type occupancy struct {
+	config *Config
+	cell   float64
+	cells  map[[2]int][]*Ball
+}
+
+// newOccupancy returns an empty occupancy for balls of maxRadius at most.
+func newOccupancy(c *Config, maxRadius float64) *occupancy {
+	return &occupancy{config: c, cell: 2 * maxRadius, cells: make(map[[2]int][]*Ball)}
+}
+
+func (o *occupancy) key(p *vector) [2]int {
+	return [2]int{int(math.Floor(p.X / o.cell)), int(math.Floor(p.Y / o.cell))}
+}
+
+// free reports whether a ball of the given radius at p overlaps no placed
+// ball nor obstacle.
+func (o *occupancy) free(p *vector, radius float64) bool {
+	if blocked(o.config, p, radius) {
+		return false
+	}
+	k := o.key(p)
+	for i := k[0] - 1; i <= k[0]+1; i++ {
+		for j := k[1] - 1; j <= k[1]+1; j++ {
+			for _, b := range o.cells[[2]int{i, j}] {
+				if b.C.distance(p) < b.Radius+radius {
+					return false
+				}
+			}
+		}
+	}
+	return true
+}
+
+func (o *occupancy) add(b *Ball) {
+	k := o.key(b.C)
+	o.cells[k] = append(o.cells[k], b)
+}
+
+// inside returns a random position of a ball of the given radius inside the
+// canvas.
+func inside(c *Config, r *rand.Rand, radius float64) *vector {
+	w, h := bounds(c)
+	return &vector{randFloat(r, radius, w-radius), randFloat(r, radius, h-radius)}
+}
+
+// rejectionPlacer draws random positions until they do not overlap the
+// balls already placed.
+type rejectionPlacer struct{}
+
+func (rejectionPlacer) place(c *Config, r *rand.Rand, balls []*Ball) error {
+	o := newOccupancy(c, c.MaxRadius)
+	for _, b := range balls {
+		placed := false
+		for i := 0; i < placementAttempts && !placed; i++ {
+			p := inside(c, r, b.Radius)
+			if placed = o.free(p, b.Radius); placed {
+				b.C = p
+				o.add(b)
+			}
+		}
+		if !placed {
+			return errNoFit(c, len(balls))
+		}
+	}
+	return nil
+}
+
+// poissonPlacer samples points at least a ball diameter apart with Bridson's
+// algorithm, then puts the balls on randomly picked ones.
+type poissonPlacer struct{}
+
+// poissonCandidates is the number of points tried around an active point.
+const poissonCandidates = 30
+
+func (poissonPlacer) place(c *Config, r *rand.Rand, balls []*Ball) error {
+	if len(balls) == 0 {
+		return nil
+	}
+	w, h := bounds(c)
+	radius := c.MaxRadius
+	dist := 2 * radius
+
+	o := newOccupancy(c, c.MaxRadius)
+	var points []*vector
+	addPoint := func(p *vector) {
+		points = append(points, p)
+		o.add(&Ball{C: p, Radius: radius})
+	}
+	first := inside(c, r, radius)
+	for i := 0; i < placementAttempts && !o.free(first, radius); i++ {
+		first = inside(c, r, radius)
+	}
+	if !o.free(first, radius) {
+		return errNoFit(c, len(balls))
+	}
+	addPoint(first)
+	active := []int{0}
+	for len(active) > 0 {
+		i := randInt(r, 0, len(active))
+		p := points[active[i]]
+		found := false
+		for k := 0; k < poissonCandidates; k++ {
+			angle := randFloat(r, 0, 2*math.Pi)
+			d := randFloat(r, dist, 2*dist)
+			q := &vector{p.X + d*math.Cos(angle), p.Y + d*math.Sin(angle)}
+			if q.X < radius || q.X > w-radius || q.Y < radius || q.Y > h-radius || !o.free(q, radius) {
+				continue
+			}
+			addPoint(q)
+			active = append(active, len(points)-1)
+			found = true
+			break
+		}
+		if !found {
+			active = append(active[:i], active[i+1:]...)
+		}
+	}
+
+	if len(points) < len(balls) {
+		return errNoFit(c, len(balls))
+	}
+	for i, j := range r.Perm(len(points))[:len(balls)] {
+		balls[i].C = points[j]
+	}
+	return nil
+}
+
+// latticePlacer spreads the balls over the canvas on a square or hexagonal
+// lattice, as loose as the ball count allows.
+type latticePlacer struct {
+	hex bool
+}
+
+func (l latticePlacer) place(c *Config, r *rand.Rand, balls []*Ball) error {
+	if len(balls) == 0 {
+		return nil
+	}
+	// the lattice gets denser as the spacing shrinks, down to touching balls
+	lo, hi := 2*c.MaxRadius, math.Max(c.CanvasWidth, c.CanvasHeight)/PTM
+	if len(l.points(c, lo)) < len(balls) {
+		return errNoFit(c, len(balls))
+	}
+	for i := 0; i < 50; i++ {
+		mid := (lo + hi) / 2
+		if len(l.points(c, mid)) >= len(balls) {
+			lo = mid
+		} else {
+			hi = mid
+		}
+	}
+	for i, p := range l.points(c, lo)[:len(balls)] {
+		balls[i].C = p
+	}
+	return nil
+}
+
+// points returns the lattice points with the given spacing, room being left
+// for the largest ball along the walls and around the obstacles.
+func (l latticePlacer) points(c *Config, spacing float64) []*vector {
+	w, h := bounds(c)
+	margin := c.MaxRadius
+	rowSpacing := spacing
+	if l.hex {
+		rowSpacing = spacing * math.Sqrt(3) / 2
+	}
+
+	var points []*vector
+	for row := 0; ; row++ {
+		y := margin + float64(row)*rowSpacing
+		if y > h-margin {
+			break
+		}
+		offset := 0.0
+		if l.hex && row%2 == 1 {
+			offset = spacing / 2
+		}
+		for x := margin + offset; x <= w-margin; x += spacing {
+			if p := (&vector{x, y}); !blocked(c, p, margin) {
+				points = append(points, p)
+			}
+		}
+	}
+	return points
+}
+
+// clusterPlacer gathers the balls around Clusters random centers, drawing
+// normally distributed positions until they do not overlap. A cluster spreads
+// out as it fills up, up to the whole canvas.
+type clusterPlacer struct{}
+
+// clusterWidening is the number of positions drawn for a ball before its
+// cluster spreads twice as wide.
+const clusterWidening = 50
+
+func (clusterPlacer) place(c *Config, r *rand.Rand, balls []*Ball) error {
+	clusters := c.Clusters
+	if clusters < 1 {
+		clusters = 1
+	}
+	w, h := bounds(c)
+	// loose enough for the balls to cover about a quarter of the disc where
+	// most of their cluster lies
+	perCluster := (len(balls) + clusters - 1) / clusters
+	spread := math.Max(math.Min(w, h)/10, c.MaxRadius*math.Sqrt(float64(perCluster)))
+	spreads := make([]float64, clusters)
+	for i := range spreads {
+		spreads[i] = spread
+	}
+
+	// centers clear of the obstacles, when possible
+	centers := make([]*vector, clusters)
+	for i := range centers {
+		centers[i] = inside(c, r, c.MaxRadius)
+		for k := 0; k < placementAttempts && blocked(c, centers[i], c.MaxRadius); k++ {
+			centers[i] = inside(c, r, c.MaxRadius)
+		}
+	}
+	o := newOccupancy(c, c.MaxRadius)
+	for i, b := range balls {
+		cluster := i % clusters
+		center := centers[cluster]
+		placed := false
+		for k := 0; k < placementAttempts && !placed; k++ {
+			if k > 0 && k%clusterWidening == 0 {
+				spreads[cluster] = math.Min(2*spreads[cluster], math.Max(w, h))
+			}
+			spread := spreads[cluster]
+			p := &vector{center.X + r.NormFloat64()*spread, center.Y + r.NormFloat64()*spread}
+			if p.X < b.Radius || p.X > w-b.Radius || p.Y < b.Radius || p.Y > h-b.Radius {
+				continue
+			}
+			if placed = o.free(p, b.Radius); placed {
+				b.C = p
+				o.add(b)
+			}
+		}
+		if !placed {
+			return errNoFit(c, len(balls))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,125 @@
+package game
+
+import (
+	"math"
+	"testing"
+)
+
+func TestPlacementWithoutOverlap(t *testing.T) {
+	for _, placement := range []string{PlaceRejection, PlacePoisson, PlaceGrid, PlaceHex, PlaceCluster} {
+		c := testConfig()
+		c.CanvasWidth, c.CanvasHeight = 200, 200
+		c.BallCount = 30
+		c.Placement = placement
+		c.Clusters = 2
+		s, err := NewSimulation(c)
+		if err != nil {
+			t.Errorf("%s: %v", placement, err)
+			continue
+		}
+
+		w, h := bounds(c)
+		for i, b1 := range s.balls {
+			if b1.C.X < b1.Radius || b1.C.X > w-b1.Radius || b1.C.Y < b1.Radius || b1.C.Y > h-b1.Radius {
+				t.Errorf("%s: ball %d outside the canvas at %v", placement, b1.Id, b1.C)
+			}
+			for _, b2 := range s.balls[i+1:] {
+				if d := b1.C.distance(b2.C); d < b1.Radius+b2.Radius {
+					t.Errorf("%s: balls %d and %d overlap", placement, b1.Id, b2.Id)
+				}
+			}
+		}
+	}
+}
+
+func TestClusterPlacementSpreads(t *testing.T) {
+	// the balls cover under a quarter of the canvas, more than a tenth of
+	// its width around two centers
+	for seed := int64(1); seed <= 200; seed++ {
+		c := testConfig()
+		c.CanvasWidth, c.CanvasHeight = 200, 200
+		c.BallCount = 30
+		c.Placement = PlaceCluster
+		c.Clusters = 2
+		c.Seed = seed
+		if _, err := NewSimulation(c); err != nil {
+			t.Errorf("seed %d: %v", seed, err)
+		}
+	}
+}
+
+func TestPlacementClearOfObstacles(t *testing.T) {
+	obstacles := []Obstacle{
+		{Type: Box, X: 5, Y: 5, Width: 6, Height: 4, Angle: 0.3},
+		{Type: Circle, X: 15, Y: 15, Radius: 3},
+		{Type: Segment, X1: 10, Y1: 2, X2: 18, Y2: 8},
+	}
+	for _, placement := range []string{PlaceRandom, PlaceRejection, PlacePoisson, PlaceGrid, PlaceHex, PlaceCluster} {
+		c := testConfig()
+		c.CanvasWidth, c.CanvasHeight = 400, 400
+		c.BallCount = 30
+		c.Placement = placement
+		c.Obstacles = obstacles
+		s, err := NewSimulation(c)
+		if err != nil {
+			t.Errorf("%s: %v", placement, err)
+			continue
+		}
+		for _, b := range s.balls {
+			for _, o := range obstacles {
+				if o.covers(b.C, b.Radius) {
+					t.Errorf("%s: ball %d at %v overlaps the %s", placement, b.Id, b.C, o.Type)
+				}
+			}
+		}
+	}
+}
+
+func TestObstacleCovers(t *testing.T) {
+	box := Obstacle{Type: Box, X: 10, Y: 10, Width: 4, Height: 2, Angle: math.Pi / 2}
+	segment := Obstacle{Type: Segment, X1: 0, Y1: 0, X2: 10, Y2: 0}
+	for _, c := range []struct {
+		o      Obstacle
+		p      *vector
+		covers bool
+	}{
+		{box, &vector{10, 10}, true},
+		// turned upright, 2 wide and 4 high
+		{box, &vector{10, 12.5}, true},
+		{box, &vector{12.5, 10}, false},
+		{segment, &vector{5, 0.5}, true},
+		{segment, &vector{11.5, 0}, false},
+	} {
+		if got := c.o.covers(c.p, 1); got != c.covers {
+			t.Errorf("Expected the %s to cover %v: %v, got %v", c.o.Type, c.p, c.covers, got)
+		}
+	}
+}
+
+func TestPlacementTooManyBalls(t *testing.T) {
+	for _, placement := range []string{PlaceRejection, PlacePoisson, PlaceGrid, PlaceHex, PlaceCluster} {
+		c := testConfig()
+		// 10m wide canvas and 2m wide balls
+		c.BallCount = 30
+		c.MinRadius = 1
+		c.Placement = placement
+		_, err := NewSimulation(c)
+		if errs, ok := err.(ValidationError); !ok || errs[0].Field != "ballCount" {
+			t.Errorf("%s: expected a ballCount error, got %v", placement, err)
+		}
+	}
+}
+
+func TestPlacementIsSeeded(t *testing.T) {
+	c1, c2 := testConfig(), testConfig()
+	c1.Placement, c2.Placement = PlacePoisson, PlacePoisson
+	c1.BallCount, c2.BallCount = 20, 20
+	c1.CanvasWidth, c2.CanvasWidth = 200, 200
+	c1.Seed, c2.Seed = 7, 7
+	s1, s2 := newTestSimulation(t, c1), newTestSimulation(t, c2)
+	for i := range s1.balls {
+		if *s1.balls[i].C != *s2.balls[i].C {
+			t.Fatal("Expected the same placement from the same seed")
+		}
+	}
+}
@@ -3,21 +3,48 @@ package game
 import (
 	"fmt"
 	"math/rand"
-	"time"
 )
 
-func init() {
-	rand.Seed(time.Now().Unix())
+// source is a math/rand source counting its draws, so that its state can be
+// saved as a seed and a number of draws.
+type source struct {
+	src   rand.Source64
+	seed  int64
+	draws uint64
 }
 
-func randFloat(min, max float64) float64 {
-	return rand.Float64()*(max-min) + min
+// newSource returns a source seeded with seed then advanced by draws.
+func newSource(seed int64, draws uint64) *source {
+	s := &source{src: rand.NewSource(seed).(rand.Source64), seed: seed}
+	for s.draws < draws {
+		s.Int63()
+	}
+	return s
 }
 
-func randInt(min, max int) int {
-	return rand.Intn(max-min) + min
+func (s *source) Int63() int64 {
+	s.draws++
+	return s.src.Int63()
 }
 
-func randomColor() string {
-	return fmt.Sprintf("#%x", uint(rand.Float64()*float64(0xffffff)))
+func (s *source) Uint64() uint64 {
+	s.draws++
+	return s.src.Uint64()
+}
+
+func (s *source) Seed(seed int64) {
+	s.src.Seed(seed)
+	s.seed, s.draws = seed, 0
+}
+
+func randFloat(r *rand.Rand, min, max float64) float64 {
+	return r.Float64()*(max-min) + min
+}
+
+func randInt(r *rand.Rand, min, max int) int {
+	return r.Intn(max-min) + min
+}
+
+func randomColor(r *rand.Rand) string {
+	return fmt.Sprintf("#%x", uint(r.Float64()*float64(0xffffff)))
 }
@@ -1,74 +1,128 @@
 package game
 
 import (
+	"container/heap"
 	"fmt"
 	"github.com/adriangonzy/websocket-balls/quadtree"
-	"sort"
+	"math"
+	"math/rand"
 	"sync"
 	"time"
 )
 
 const (
 	PTM = 10 // pixel to meter ratio
-)
-
-type Config struct {
-	CanvasHeight     float64 `json: canvasHeight` // pixels
-	CanvasWidth      float64 `json: canvasWidth`  // pixels
-	MaxRadius        float64 `json: maxRadius`    // meter
-	MinRadius        float64 `json: minRadius`    // meter
-	MaxVelocity      float64 `json: maxVelocity`  // meter/s
-	MinVelocity      float64 `json: minVelocity`  // meter/s
-	MaxMass          float64 `json: maxMass`      // kg
-	MinMass          float64 `json: minMass`      // kg
-	FrameRate        int     `json: frameRate`    // frames/s
-	SearchAreaFactor int     `json: searchAreaFactor`
-	BallCount        int
 
-	Frame time.Duration // frame in ms
-}
+	// time scale bounds, from slow motion to fast-forward
+	MinTimeScale = 0.1
+	MaxTimeScale = 10
+)
 
 type Simulation struct {
 	config     *Config
+	source     *source
+	rand       *rand.Rand
 	balls      []*Ball
-	Emit       chan [][]interface{}
+	Emit       chan *Frame
 	done       chan bool
+	stopped    chan struct{}
+	actions    chan func()
 	collisions []*Collision
+	frames     int
+	paused     bool
+	// frames left to step, one per loop iteration
+	steps int
+	// simulated time owed to the wall clock
+	accumulator time.Duration
+	// frame rate ticker, once started
+	ticker *time.Ticker
+	// ID of the next ball added
+	nextID int
+	// surfaces of the canvas walls and the config obstacles
+	world []surface
 }
 
-func NewSimulation(c *Config) *Simulation {
-	c.Frame = time.Duration(1000/c.FrameRate) * time.Millisecond
-	fmt.Printf("NEW SIMULATION %#v\n", c)
+// NewSimulation returns a simulation of BallCount random balls, positioned
+// with the config placement strategy. It fails when the config is invalid or
+// the balls do not fit in the canvas.
+func NewSimulation(c *Config) (*Simulation, error) {
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	s := newSimulation(c)
 
-	//init random balls array
-	//TODO: uniformly spread balls accross the canvas for avoiding early ball collisions
 	balls := make([]*Ball, c.BallCount)
 	for i := range balls {
-		balls[i] = NewRandomBall(c)
-		balls[i].Id = i
+		balls[i] = NewRandomBall(c, s.rand)
+	}
+	if err := placeBalls(c, s.rand, balls); err != nil {
+		return nil, err
+	}
+	for _, b := range balls {
+		s.addBall(b)
 	}
+	return s, nil
+}
+
+// newSimulation returns a simulation with no balls and its random source
+// seeded from the config.
+func newSimulation(c *Config) *Simulation {
+	c.prepare()
+	fmt.Printf("NEW SIMULATION %#v\n", c)
 
+	src := newSource(c.Seed, 0)
 	return &Simulation{
-		balls:  balls,
-		config: c,
-		Emit:   make(chan [][]interface{}),
-		done:   make(chan bool),
+		config:  c,
+		world:   buildWorld(c),
+		source:  src,
+		rand:    rand.New(src),
+		Emit:    make(chan *Frame),
+		done:    make(chan bool),
+		stopped: make(chan struct{}),
+		actions: make(chan func()),
 	}
 }
 
 func (s *Simulation) Start() {
 	fmt.Println("START SIMULATION")
 	ticker := time.NewTicker(s.config.Frame)
-	var frames int
+	s.ticker = ticker
 	go func() {
+		defer close(s.stopped)
+		defer ticker.Stop()
+		last := time.Now()
+		rendered := s.frames
 		for {
+			// always ready when computing as fast as possible
+			var fast chan struct{}
+			if s.steps > 0 || s.config.Headless && !s.paused {
+				fast = ready
+			}
+
 			select {
-			case <-ticker.C:
-				// TODO: block until current frame is finished
-				frames = frames + 1
-				fmt.Println("frame", frames)
-				s.run(s.config.Frame)
-				fmt.Println("===================")
+			case f := <-s.actions:
+				f()
+			case <-fast:
+				s.step()
+				if s.steps > 0 {
+					s.steps--
+					s.emit()
+				}
+			case now := <-ticker.C:
+				// ticks missed while computing show up in the elapsed time
+				elapsed := now.Sub(last)
+				last = now
+				switch {
+				case s.paused:
+				case s.config.Headless:
+					// only render, frames are computed ahead of the ticker
+					if s.frames != rendered {
+						s.emit()
+					}
+				case s.tick(elapsed) > 0:
+					s.emit()
+				}
+				rendered = s.frames
 			case <-s.done:
 				return
 			}
@@ -76,12 +130,230 @@ func (s *Simulation) Start() {
 	}()
 }
 
+// ready is a closed channel, always ready to receive from.
+var ready = make(chan struct{})
+
+func init() {
+	close(ready)
+}
+
+// tick advances the simulation by as many fixed frames as fit in the elapsed
+// wall clock time, keeping the remainder for the next tick. It gives up on
+// catching up after MaxCatchUp frames so that an overloaded server slows the
+// simulation down instead of spiralling. It returns the computed frames.
+func (s *Simulation) tick(elapsed time.Duration) int {
+	s.accumulator += time.Duration(float64(elapsed) * s.config.TimeScale)
+	// fast-forward legitimately computes several frames per tick
+	max := s.config.MaxCatchUp * int(math.Ceil(s.config.TimeScale))
+	frames := 0
+	for s.accumulator >= s.config.Frame {
+		if frames == max {
+			s.accumulator = 0
+			break
+		}
+		s.step()
+		s.accumulator -= s.config.Frame
+		frames++
+	}
+	return frames
+}
+
+// step computes the next frame in Substeps physics steps.
+func (s *Simulation) step() {
+	s.frames = s.frames + 1
+	fmt.Println("frame", s.frames)
+	delta := s.config.Frame / time.Duration(s.config.Substeps)
+	for i := 0; i < s.config.Substeps; i++ {
+		s.run(delta)
+	}
+	fmt.Println("===================")
+}
+
+// emit streams the balls after movement computations.
+func (s *Simulation) emit() {
+	s.Emit <- s.compressBalls()
+}
+
+// Pause freezes the simulation until Resume is called. Frames can still be
+// advanced one by one with Step.
+func (s *Simulation) Pause() {
+	s.do(func() {
+		s.paused = true
+		s.steps = 0
+	})
+}
+
+// Resume restarts a paused simulation.
+func (s *Simulation) Resume() {
+	s.do(func() {
+		s.paused = false
+		s.steps = 0
+		s.accumulator = 0
+	})
+}
+
+// SetTimeScale changes the simulation speed, from MinTimeScale slow motion to
+// MaxTimeScale fast-forward.
+func (s *Simulation) SetTimeScale(scale float64) {
+	s.do(func() {
+		s.config.TimeScale = clampTimeScale(scale)
+	})
+}
+
+// SetHeadless switches to computing frames as fast as possible, emitting the
+// latest one at the frame rate, or back to real time.
+func (s *Simulation) SetHeadless(headless bool) {
+	s.do(func() {
+		s.config.Headless = headless
+		s.accumulator = 0
+	})
+}
+
+// Config returns a copy of the simulation config, nil once the simulation is
+// stopped.
+func (s *Simulation) Config() *Config {
+	configs := make(chan *Config, 1)
+	if !s.do(func() { configs <- s.config.copy() }) {
+		return nil
+	}
+	return <-configs
+}
+
+// SetConfig applies a new config between two frames, keeping the balls and
+// the random seed. Balls left outside a shrunken canvas or inside a new
+// obstacle are moved to a free position, ball velocities are scaled along
+// with MaxVelocity and the default restitution and friction apply to every
+// ball. The other ball ranges only apply to the balls spawned afterwards, and
+// BallCount to the next start. The drag fields must hold the live balls
+// lighter than MinMass too.
+func (s *Simulation) SetConfig(c *Config) error {
+	if err := c.Validate(); err != nil {
+		return err
+	}
+	c = c.copy()
+	errs := make(chan error, 1)
+	if !s.do(func() { errs <- s.setConfig(c) }) {
+		return errStopped
+	}
+	return <-errs
+}
+
+func (s *Simulation) setConfig(c *Config) error {
+	for _, b := range s.balls {
+		if err := c.checkDrag(b.Mass); err != nil {
+			return err
+		}
+	}
+	old := s.config
+	c.Seed = old.Seed
+	c.prepare()
+	s.config = c
+	s.world = buildWorld(c)
+
+	if c.Frame != old.Frame {
+		s.accumulator = 0
+		if s.ticker != nil {
+			s.ticker.Reset(c.Frame)
+		}
+	}
+	var displaced []*Ball
+	for _, b := range s.balls {
+		if c.MaxVelocity != old.MaxVelocity && old.MaxVelocity > 0 {
+			b.V = b.V.multiply(c.MaxVelocity / old.MaxVelocity)
+		}
+		if c.Restitution != old.Restitution {
+			b.Restitution = c.Restitution
+		}
+		if c.Friction != old.Friction {
+			b.Friction = c.Friction
+		}
+		x, y := b.C.X, b.C.Y
+		if c.Boundary == BoundaryReflect {
+			b.C.X = clampToCanvas(b.C.X, b.Radius, c.CanvasWidth)
+			b.C.Y = clampToCanvas(b.C.Y, b.Radius, c.CanvasHeight)
+		}
+		if b.C.X != x || b.C.Y != y || blocked(c, b.C, b.Radius) {
+			displaced = append(displaced, b)
+		}
+	}
+	s.replace(displaced)
+	s.applyBoundary()
+	return nil
+}
+
+// replace moves the displaced balls to a random position inside the canvas
+// when they overlap an obstacle or another ball. A ball finding no free
+// position stays where it is, for the collisions to push it out.
+func (s *Simulation) replace(displaced []*Ball) {
+	if len(displaced) == 0 {
+		return
+	}
+	moved := make(map[*Ball]bool, len(displaced))
+	for _, b := range displaced {
+		moved[b] = true
+	}
+	maxRadius := 0.0
+	for _, b := range s.balls {
+		maxRadius = math.Max(maxRadius, b.Radius)
+	}
+	o := newOccupancy(s.config, maxRadius)
+	for _, b := range s.balls {
+		if !moved[b] {
+			o.add(b)
+		}
+	}
+	for _, b := range displaced {
+		for i := 0; i < placementAttempts && !o.free(b.C, b.Radius); i++ {
+			if p := inside(s.config, s.rand, b.Radius); o.free(p, b.Radius) {
+				b.C = p
+			}
+		}
+		o.add(b)
+	}
+}
+
+// clampToCanvas returns the ball coordinate x in meters moved inside a canvas
+// side of the given size in pixels.
+func clampToCanvas(x, radius, size float64) float64 {
+	return math.Max(radius, math.Min(x, size/PTM-radius))
+}
+
+func clampTimeScale(scale float64) float64 {
+	if scale == 0 {
+		return 1
+	}
+	return math.Max(MinTimeScale, math.Min(MaxTimeScale, scale))
+}
+
+// Step pauses the simulation then advances it by n frames, emitting each of
+// them. The frames are computed one per loop iteration, so that the actions
+// and Stop are still served in between, until Pause or Resume.
+func (s *Simulation) Step(n int) {
+	s.do(func() {
+		s.paused = true
+		s.steps = n
+	})
+}
+
+// Stop ends the simulation for good and closes Emit. Use Pause to freeze it
+// instead.
 func (s *Simulation) Stop() {
 	fmt.Println("STOP SIMULATION")
 	s.done <- true
 	close(s.Emit)
 }
 
+// do runs f in the simulation goroutine, between two frames. It is a no-op
+// once the simulation is stopped, and reports whether f is run.
+func (s *Simulation) do(f func()) bool {
+	select {
+	case s.actions <- f:
+		return true
+	case <-s.stopped:
+		return false
+	}
+}
+
 func print(msg string) {
 	fmt.Println(msg)
 }
@@ -89,25 +361,22 @@ func print(msg string) {
 // Compute simulation balls movement during one frame
 func (s *Simulation) run(delta time.Duration) {
 	start := time.Now()
+	s.applyFields()
 	s.computeCollisions(delta)
 	fmt.Println("collisions", len(s.collisions), "time", time.Since(start))
 
 	fmt.Printf("%#v\n", s.balls)
 	fmt.Println("compute")
 	if len(s.collisions) > 0 {
-		s.sortCollisions()
-		fmt.Printf("%#v\n", s.balls)
-		fmt.Println("sort")
-		s.moveAfterCollisions()
+		s.moveAfterCollisions(delta)
 		fmt.Printf("%#v\n", s.balls)
 		fmt.Println("move after")
 	}
 	s.finishMoving(delta)
+	s.applyBoundary()
 	fmt.Printf("%#v\n", s.balls)
 	fmt.Println("finish")
 
-	// stream ball slice after movement computations
-	s.Emit <- s.compressBalls()
 	fmt.Println(time.Since(start))
 }
 
@@ -117,73 +386,174 @@ func (s *Simulation) computeCollisions(delta time.Duration) {
 
 	// init collision reception channel
 	cols := make(chan *Collision)
+	collected := make(chan bool)
 	go func() {
 		for c := range cols {
 			s.collisions = append(s.collisions, c)
 		}
+		close(collected)
 	}()
 
 	// number of ball pairs
 	var wg sync.WaitGroup
 
-	box := quadtree.Box{
-		s.config.CanvasWidth / 2,
-		s.config.CanvasHeight / 2,
-		s.config.CanvasWidth / 2,
-		s.config.CanvasHeight / 2,
-	}
-	q := quadtree.New(box, 10)
+	box := quadtree.NewBox(
+		s.config.CanvasWidth/2,
+		s.config.CanvasHeight/2,
+		s.config.CanvasWidth/2,
+		s.config.CanvasHeight/2,
+	)
+	q := quadtree.New(*box, 10)
 
 	for _, b := range s.balls {
 		q.Insert(b)
 	}
 
-	// concurrently compute pairs of balls collisions
+	// concurrently compute pairs of balls collisions, and collisions with
+	// the obstacles
+	images := s.images()
 	for _, b1 := range s.balls {
+		wg.Add(1)
+		go func(b *Ball) {
+			for _, c := range s.obstacleCollisions(b, delta) {
+				cols <- c
+			}
+			wg.Done()
+		}(b1)
+
 		searchArea := s.config.MaxRadius * float64(s.config.SearchAreaFactor) * PTM
-		area := quadtree.Box{b1.C.X, b1.C.Y, searchArea, searchArea}
-		// this could be optimized
-		neighbors := q.SearchArea(&area)
-		wg.Add(len(neighbors))
-		for _, n := range neighbors {
-			b2 := n.(*Ball)
-			go func(b1, b2 *Ball) {
-				if c, ok := collisionInFrame(b1, b2, delta); ok {
-					cols <- c
+		for _, offset := range images {
+			// balls whose copy at offset is around b1
+			area := quadtree.NewBox(b1.C.X-offset.X, b1.C.Y-offset.Y, searchArea, searchArea)
+			// this could be optimized
+			neighbors := q.SearchArea(area)
+			for _, n := range neighbors {
+				b2 := n.(*Ball)
+				// each pair once
+				if b2.Id <= b1.Id {
+					continue
 				}
-				wg.Done()
-			}(b1, b2)
+				wg.Add(1)
+				go func(b1, b2 *Ball, offset *vector) {
+					image := *b2
+					image.C = b2.C.add(offset)
+					if c, ok := collisionInFrame(b1, &image, delta); ok {
+						c.B2, c.offset = b2, offset
+						cols <- c
+					}
+					wg.Done()
+				}(b1, b2, offset)
+			}
 		}
 	}
 
 	wg.Wait()
 	close(cols)
+	<-collected
 }
 
-func (s *Simulation) moveAfterCollisions() {
-	collided := make(map[int]bool)
-	// compute ball movement given collision slice
-	for _, c := range s.collisions {
-		if collided[c.B1.Id] || collided[c.B2.Id] {
+// maxEventsPerBall bounds the collisions resolved within a frame, per ball,
+// so that balls squeezed together cannot stall the simulation.
+const maxEventsPerBall = 32
+
+// moveAfterCollisions resolves the frame collisions as events, earliest
+// first: the balls involved are moved to the collision time and react, then
+// their next collisions are predicted again from there, until the end of the
+// frame. Chains of collisions, as in Newton's cradle, are resolved within a
+// single frame.
+func (s *Simulation) moveAfterCollisions(delta time.Duration) {
+	epochs := make(map[int]int)
+	queue := &collisionQueue{ByTime(s.collisions)}
+	heap.Init(queue)
+	limit := maxEventsPerBall * len(s.balls)
+	in := s.integrator()
+	events := 0
+	for queue.Len() > 0 {
+		c := heap.Pop(queue).(*Collision)
+		if c.epoch1 != epochs[c.B1.Id] || c.B2 != nil && c.epoch2 != epochs[c.B2.Id] {
+			// predicted along a former trajectory
 			continue
 		}
-		collided[c.B1.Id], collided[c.B2.Id] = true, true
+		if events == limit {
+			fmt.Println("collision events limit reached at", c.moment)
+			return
+		}
+		events++
+
 		// move balls to collision time
-		c.B1.move(c.moment)
-		c.B2.move(c.moment)
+		balls := []*Ball{c.B1}
+		if c.B2 != nil {
+			balls = append(balls, c.B2)
+		}
+		for _, b := range balls {
+			b.move(in, c.moment-b.moved)
+		}
 		c.reaction()
+		for _, b := range balls {
+			epochs[b.Id]++
+		}
+		for i, b := range balls {
+			// the pair itself is predicted once
+			for _, next := range s.predict(b, delta, balls[:i]) {
+				next.epoch1 = epochs[next.B1.Id]
+				if next.B2 != nil {
+					next.epoch2 = epochs[next.B2.Id]
+				}
+				heap.Push(queue, next)
+			}
+		}
+	}
+}
+
+// predict returns the collisions of the ball from where it is now until the
+// end of the frame, with the world surfaces and the other balls but the
+// skipped ones.
+func (s *Simulation) predict(b *Ball, delta time.Duration, skip []*Ball) []*Collision {
+	now := b.moved
+	seconds := float64(delta-now) / float64(time.Second)
+	collisions := s.obstacleCollisions(b, delta-now)
+	for _, c := range collisions {
+		c.moment += now
+	}
+
+	images := s.images()
+next:
+	for _, other := range s.balls {
+		if other == b {
+			continue
+		}
+		for _, o := range skip {
+			if other == o {
+				continue next
+			}
+		}
+		// where the other ball is now
+		projected := *other
+		projected.move(s.integrator(), now-other.moved)
+		for _, offset := range images {
+			image := projected
+			image.C = projected.C.add(offset)
+			if b.C.distance(image.C) > b.Radius+other.Radius+reach(b, seconds)+reach(&image, seconds) {
+				continue
+			}
+			if c, ok := collisionInFrame(b, &image, delta-now); ok {
+				c.B2, c.offset = other, offset
+				c.moment += now
+				collisions = append(collisions, c)
+			}
+		}
 	}
+	return collisions
 }
 
 func (s *Simulation) finishMoving(delta time.Duration) {
 	// finish moving balls concurrently in frame
 	var wg sync.WaitGroup
 	wg.Add(len(s.balls))
+	in := s.integrator()
 	for i, b := range s.balls {
 		go func(b *Ball, i int) {
-			// TODO: wall collision computed the same way as ball collision
-			b.wallCollision(s.config.CanvasWidth, s.config.CanvasHeight)
-			b.move(delta - b.moved)
+			b.move(in, delta-b.moved)
 			b.moved = 0
 			wg.Done()
 		}(b, i)
@@ -191,29 +561,51 @@ func (s *Simulation) finishMoving(delta time.Duration) {
 	wg.Wait()
 }
 
-func (s *Simulation) compressBalls() [][]interface{} {
-	// change to pixel unit and compress sent data
-	compressedBalls := make([][]interface{}, len(s.balls))
+func (s *Simulation) compressBalls() *Frame {
+	// change to pixel unit and keep only the drawn data
+	f := &Frame{Number: s.frames, Balls: make([]BallState, len(s.balls))}
 	fmt.Printf("%#v\n", s.balls)
 	for i, b := range s.balls {
 		p := b.C.multiply(PTM)
-		compressedBalls[i] = []interface{}{
-			p.X,
-			p.Y,
-			b.Radius * PTM,
-			b.Color,
+		f.Balls[i] = BallState{
+			Id:     b.Id,
+			X:      p.X,
+			Y:      p.Y,
+			Radius: b.Radius * PTM,
+			Color:  b.Color,
+			Angle:  b.Angle,
 		}
 	}
-	return compressedBalls
+	return f
 }
 
+// ByTime orders collisions by moment, then by ball IDs so that collisions
+// found concurrently are always resolved in the same order.
 type ByTime []*Collision
 
-func (a ByTime) Len() int           { return len(a) }
-func (a ByTime) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
-func (a ByTime) Less(i, j int) bool { return a[i].moment < a[j].moment }
+func (a ByTime) Len() int      { return len(a) }
+func (a ByTime) Swap(i, j int) { a[i], a[j] = a[j], a[i] }
+func (a ByTime) Less(i, j int) bool {
+	if a[i].moment != a[j].moment {
+		return a[i].moment < a[j].moment
+	}
+	if a[i].B1.Id != a[j].B1.Id {
+		return a[i].B1.Id < a[j].B1.Id
+	}
+	return a[i].other() < a[j].other()
+}
 
-func (s *Simulation) sortCollisions() {
+// collisionQueue is a priority queue of collisions, the earliest first.
+type collisionQueue struct {
+	ByTime
+}
+
+func (q *collisionQueue) Push(x interface{}) {
+	q.ByTime = append(q.ByTime, x.(*Collision))
+}
 
-	sort.Sort(ByTime(s.collisions))
+func (q *collisionQueue) Pop() interface{} {
+	last := q.ByTime[len(q.ByTime)-1]
+	q.ByTime = q.ByTime[:len(q.ByTime)-1]
+	return last
 }
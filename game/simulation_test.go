@@ -1,32 +1,318 @@
 package game
 
 import (
+	"math"
+	"math/rand"
+	"reflect"
 	"testing"
+	"time"
 )
 
+var testRand = rand.New(rand.NewSource(1))
+
+func testConfig() *Config {
+	return &Config{
+		CanvasHeight:     100,
+		CanvasWidth:      100,
+		MaxRadius:        1,
+		MinRadius:        0.5,
+		MaxVelocity:      5,
+		MinVelocity:      1,
+		MaxMass:          2,
+		MinMass:          1,
+		FrameRate:        100,
+		SearchAreaFactor: 3,
+		BallCount:        2,
+		Restitution:      1,
+	}
+}
+
+// newTestSimulation returns a new simulation, failing the test on error.
+func newTestSimulation(t *testing.T, c *Config) *Simulation {
+	s, err := NewSimulation(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+// worldTestSimulation returns a simulation of the given balls only, in a 40m
+// canvas, its config being changed by configure first when not nil.
+func worldTestSimulation(t *testing.T, configure func(c *Config), balls ...*Ball) *Simulation {
+	c := testConfig()
+	c.CanvasWidth, c.CanvasHeight = 400, 400
+	c.BallCount = 0
+	if configure != nil {
+		configure(c)
+	}
+	s := newTestSimulation(t, c)
+	for _, b := range balls {
+		s.addBall(b)
+	}
+	return s
+}
+
+// startedTestSimulation starts a paused simulation, discarding its frames.
+func startedTestSimulation(t *testing.T, c *Config) *Simulation {
+	s := newTestSimulation(t, c)
+	go func() {
+		for range s.Emit {
+		}
+	}()
+	s.Start()
+	s.Pause()
+	return s
+}
+
 func TestStartSimulation(t *testing.T) {
-	NewSimulation(2).Start()
+	s := newTestSimulation(t, testConfig())
+	s.Start()
+	<-s.Emit
+	s.Stop()
+}
+
+func TestStepSimulation(t *testing.T) {
+	s := newTestSimulation(t, testConfig())
+	s.Start()
+	defer s.Stop()
+
+	go s.Step(3)
+	for i := 0; i < 3; i++ {
+		select {
+		case <-s.Emit:
+		case <-time.After(time.Second):
+			t.Fatal("Expected 3 stepped frames, got", i)
+		}
+	}
+
+	select {
+	case <-s.Emit:
+		t.Error("Expected no frame while paused")
+	case <-time.After(5 * s.config.Frame):
+	}
+}
+
+func TestStepManyFrames(t *testing.T) {
+	s := newTestSimulation(t, testConfig())
+	s.Start()
+	go func() {
+		for range s.Emit {
+		}
+	}()
+
+	s.Step(1000000000)
+	stopped := make(chan struct{})
+	go func() {
+		s.Stop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Fatal("Expected the simulation to stop while stepping")
+	}
+}
+
+func TestSeededSimulation(t *testing.T) {
+	frames := func(seed int64) []*Frame {
+		c := testConfig()
+		c.BallCount = 50
+		c.Seed = seed
+		s := newTestSimulation(t, c)
+		var frames []*Frame
+		for i := 0; i < 50; i++ {
+			s.step()
+			frames = append(frames, s.compressBalls())
+		}
+		return frames
+	}
+
+	if !reflect.DeepEqual(frames(42), frames(42)) {
+		t.Error("Expected identical frames for identical seeds")
+	}
+	if reflect.DeepEqual(frames(42), frames(43)) {
+		t.Error("Expected different frames for different seeds")
+	}
+}
+
+func TestTickAccumulator(t *testing.T) {
+	c := testConfig()
+	c.Substeps = 2
+	c.MaxCatchUp = 3
+	s := newTestSimulation(t, c)
+
+	if n := s.tick(c.Frame*2 + c.Frame/2); n != 2 || s.accumulator != c.Frame/2 {
+		t.Error("Expected 2 frames and half a frame left, got", n, s.accumulator)
+	}
+	if n := s.tick(c.Frame / 2); n != 1 || s.accumulator != 0 {
+		t.Error("Expected 1 frame and nothing left, got", n, s.accumulator)
+	}
+	// too late to catch up, the backlog is dropped
+	if n := s.tick(c.Frame * 10); n != 3 || s.accumulator != 0 {
+		t.Error("Expected 3 frames and nothing left, got", n, s.accumulator)
+	}
+	if s.frames != 6 {
+		t.Error("Expected 6 frames computed, got", s.frames)
+	}
+}
+
+func TestTimeScale(t *testing.T) {
+	c := testConfig()
+	c.TimeScale = 2
+	s := newTestSimulation(t, c)
+	if n := s.tick(c.Frame); n != 2 {
+		t.Error("Expected 2 frames per tick in fast-forward, got", n)
+	}
+
+	s.config.TimeScale = 0.5
+	if n := s.tick(c.Frame); n != 0 {
+		t.Error("Expected no frame after half a frame in slow motion, got", n)
+	}
+	if n := s.tick(c.Frame); n != 1 {
+		t.Error("Expected 1 frame after a whole frame in slow motion, got", n)
+	}
+
+	if clampTimeScale(100) != MaxTimeScale || clampTimeScale(0.001) != MinTimeScale {
+		t.Error("Expected time scale to be clamped")
+	}
+}
+
+func TestHeadlessSimulation(t *testing.T) {
+	c := testConfig()
+	c.FrameRate = 10
+	c.Headless = true
+	s := newTestSimulation(t, c)
+	go func() {
+		for range s.Emit {
+		}
+	}()
+	s.Start()
+	time.Sleep(2 * c.Frame)
+	s.Stop()
+	<-s.stopped
+
+	// the ticker alone would have computed 2 frames
+	if s.frames <= 10 {
+		t.Error("Expected headless simulation to outrun the ticker, got", s.frames, "frames")
+	}
+}
+
+func TestSetConfig(t *testing.T) {
+	c := testConfig()
+	c.BallCount = 20
+	s := startedTestSimulation(t, c)
+	defer s.Stop()
+	before := s.Snapshot()
+
+	update := s.Config()
+	update.CanvasWidth = 40
+	update.MaxVelocity = 2 * c.MaxVelocity
+	update.FrameRate = 50
+	if err := s.SetConfig(update); err != nil {
+		t.Fatal(err)
+	}
+	after := s.Snapshot()
+
+	if after.Config.Seed != before.Config.Seed || after.Config.Frame != 20*time.Millisecond {
+		t.Errorf("Unexpected config after update: %+v", after.Config)
+	}
+	for i, b := range after.Balls {
+		if b.C.X+b.Radius > 40/PTM+1e-9 {
+			t.Errorf("Ball %d left outside the canvas at %v", b.Id, b.C.X)
+		}
+		if v := before.Balls[i].V.multiply(2); math.Abs(v.X-b.V.X) > 1e-9 || math.Abs(v.Y-b.V.Y) > 1e-9 {
+			t.Errorf("Ball %d velocity not scaled: %v to %v", b.Id, before.Balls[i].V, b.V)
+		}
+	}
+
+	update.FrameRate = 0
+	if err := s.SetConfig(update); err == nil {
+		t.Error("Expected an invalid config to be refused")
+	}
+}
+
+func TestSetConfigDisplacedBalls(t *testing.T) {
+	c := testConfig()
+	c.BallCount = 0
+	s := startedTestSimulation(t, c)
+	defer s.Stop()
+	r, v := 0.5, 0.0
+	for _, x := range []float64{95, 75, 20} {
+		y := 20.0
+		if _, err := s.AddBall(&BallSpec{X: &x, Y: &y, VX: &v, VY: &v, Radius: &r}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the first ball is clamped onto the second one, the third one is left
+	// inside the new obstacle
+	update := s.Config()
+	update.CanvasWidth = 80
+	update.Obstacles = []Obstacle{{Type: Circle, X: 2, Y: 2, Radius: 1}}
+	if err := s.SetConfig(update); err != nil {
+		t.Fatal(err)
+	}
+	balls := s.Snapshot().Balls
+	for i, b1 := range balls {
+		if blocked(update, b1.C, b1.Radius) {
+			t.Errorf("Ball %d left in the obstacle at %v", b1.Id, b1.C)
+		}
+		if b1.C.X+b1.Radius > 80/PTM+1e-9 {
+			t.Errorf("Ball %d left outside the canvas at %v", b1.Id, b1.C)
+		}
+		for _, b2 := range balls[i+1:] {
+			if b1.C.distance(b2.C) < b1.Radius+b2.Radius {
+				t.Errorf("Balls %d and %d overlap", b1.Id, b2.Id)
+			}
+		}
+	}
+}
+
+func TestSetConfigDragLighterBalls(t *testing.T) {
+	c := testConfig()
+	c.BallCount = 0
+	c.MinMass = 0.5
+	s := startedTestSimulation(t, c)
+	defer s.Stop()
+	m := 0.5
+	if _, err := s.AddBall(&BallSpec{Mass: &m}); err != nil {
+		t.Fatal(err)
+	}
+
+	// 100 frames/s and 1kg balls hold a drag of 80, the live 0.5kg ball not
+	update := s.Config()
+	update.MinMass = 1
+	update.Fields = []Field{{Type: Drag, Strength: 80}}
+	if err := update.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SetConfig(update); err == nil {
+		t.Error("Expected a drag too strong for a live ball to be refused")
+	}
+	if fields := s.Config().Fields; len(fields) != 0 {
+		t.Error("Expected the refused config to be left out, got fields", fields)
+	}
 }
 
 func makeTestBalls() []*Ball {
 	balls := make([]*Ball, 2)
 
 	balls[0] = &Ball{
-		Id:       1,
-		Position: &vector{10, 10},
-		velocity: &vector{20, 0},
-		Radius:   randFloat(1, 4),
-		mass:     4,
-		Color:    randomColor(),
+		Id:     1,
+		C:      &vector{10, 10},
+		V:      &vector{20, 0},
+		Radius: randFloat(testRand, 1, 4),
+		Mass:   4,
+		Color:  randomColor(testRand),
 	}
 
 	balls[1] = &Ball{
-		Id:       2,
-		Position: &vector{22, 10},
-		velocity: &vector{-13, 0},
-		Radius:   randFloat(1, 7),
-		mass:     1,
-		Color:    randomColor(),
+		Id:     2,
+		C:      &vector{22, 10},
+		V:      &vector{-13, 0},
+		Radius: randFloat(testRand, 1, 7),
+		Mass:   1,
+		Color:  randomColor(testRand),
 	}
 
 	return balls
@@ -0,0 +1,173 @@
+package game
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+)
+
+var errInvalidSnapshot = errors.New("invalid snapshot: missing config or ball vectors")
+
+// MaxSnapshotDraws bounds the random draws of a snapshot, replayed one by one
+// to restore the random source.
+const MaxSnapshotDraws = 1 << 26
+
+// Snapshot is the full state of a simulation, enough to carry on computing
+// the very same frames.
+type Snapshot struct {
+	Config *Config `json:"config"`
+	// random numbers drawn since seeding with Config.Seed
+	Draws  uint64  `json:"draws"`
+	Frames int     `json:"frames"`
+	Balls  []*Ball `json:"balls"`
+	// ID of the next ball added, so that removed ball IDs are not reused
+	NextID int `json:"nextId,omitempty"`
+}
+
+// Snapshot returns the simulation state between two frames, nil once the
+// simulation is stopped.
+func (s *Simulation) Snapshot() *Snapshot {
+	snapshots := make(chan *Snapshot, 1)
+	if !s.do(func() { snapshots <- s.snapshot() }) {
+		return nil
+	}
+	return <-snapshots
+}
+
+// Restore replaces the simulation state with the snapshot one.
+func (s *Simulation) Restore(sn *Snapshot) error {
+	if err := sn.check(); err != nil {
+		return err
+	}
+	sn = sn.copy()
+	if !s.do(func() { s.restore(sn) }) {
+		return errStopped
+	}
+	return nil
+}
+
+// NewSimulationFromSnapshot returns a simulation starting from the snapshot
+// state.
+func NewSimulationFromSnapshot(sn *Snapshot) (*Simulation, error) {
+	if err := sn.check(); err != nil {
+		return nil, err
+	}
+	sn = sn.copy()
+	s := newSimulation(sn.Config)
+	s.restore(sn)
+	return s, nil
+}
+
+func (s *Simulation) snapshot() *Snapshot {
+	sn := &Snapshot{
+		Config: s.config,
+		Draws:  s.source.draws,
+		Frames: s.frames,
+		Balls:  s.balls,
+		NextID: s.nextID,
+	}
+	return sn.copy()
+}
+
+func (s *Simulation) restore(sn *Snapshot) {
+	sn.Config.prepare()
+	if s.ticker != nil && sn.Config.Frame != s.config.Frame {
+		s.ticker.Reset(sn.Config.Frame)
+	}
+	s.config = sn.Config
+	s.world = buildWorld(sn.Config)
+	s.source = newSource(sn.Config.Seed, sn.Draws)
+	s.rand = rand.New(s.source)
+	s.frames = sn.Frames
+	s.balls = sn.Balls
+	s.nextID = sn.NextID
+	for _, b := range s.balls {
+		if b.Id >= s.nextID {
+			s.nextID = b.Id + 1
+		}
+	}
+	s.accumulator = 0
+}
+
+// check makes sure a decoded snapshot can be restored.
+func (sn *Snapshot) check() error {
+	if sn.Config == nil {
+		return errInvalidSnapshot
+	}
+	if err := sn.Config.Validate(); err != nil {
+		return err
+	}
+	if sn.Draws > MaxSnapshotDraws {
+		return fmt.Errorf("invalid snapshot: more than %d random draws", MaxSnapshotDraws)
+	}
+	ids := make(map[int]bool, len(sn.Balls))
+	for _, b := range sn.Balls {
+		if b == nil || b.C == nil || b.V == nil {
+			return errInvalidSnapshot
+		}
+		if err := b.check(sn.Config); err != nil {
+			return fmt.Errorf("invalid snapshot: ball %d %v", b.Id, err)
+		}
+		if err := sn.Config.checkDrag(b.Mass); err != nil {
+			return fmt.Errorf("invalid snapshot: %v", err)
+		}
+		// collisions are told apart by ball IDs
+		if ids[b.Id] {
+			return fmt.Errorf("invalid snapshot: duplicate ball %d", b.Id)
+		}
+		ids[b.Id] = true
+	}
+	return nil
+}
+
+// check makes sure a decoded ball can be simulated. The balls may predate a
+// config change, so only the canvas bounds their radius.
+func (b *Ball) check(c *Config) error {
+	finite := func(vs ...float64) bool {
+		for _, v := range vs {
+			if math.IsNaN(v) || math.IsInf(v, 0) {
+				return false
+			}
+		}
+		return true
+	}
+	switch {
+	case b.Id < 0:
+		return errors.New("ID must not be negative")
+	case !finite(b.C.X, b.C.Y, b.V.X, b.V.Y, b.Angle, b.W):
+		return errors.New("position, velocity and angle must be finite")
+	case !(b.Radius > 0 && 2*b.Radius*PTM <= c.CanvasWidth && 2*b.Radius*PTM <= c.CanvasHeight):
+		return errors.New("radius must be positive and fit in the canvas")
+	case !(b.Mass > 0) || math.IsInf(b.Mass, 1):
+		return errors.New("mass must be a positive number")
+	case !(b.Restitution >= 0 && b.Restitution <= 1):
+		return errors.New("restitution must be between 0 and 1")
+	case !(b.Friction >= 0) || math.IsInf(b.Friction, 1):
+		return errors.New("friction must not be negative")
+	case !colorPattern.MatchString(b.Color):
+		return errors.New("color must be a hexadecimal #rrggbb color or a color name")
+	}
+	return nil
+}
+
+// copy returns a deep copy of the snapshot, sharing nothing with the running
+// simulation.
+func (sn *Snapshot) copy() *Snapshot {
+	balls := make([]*Ball, len(sn.Balls))
+	for i, b := range sn.Balls {
+		cb := *b
+		cb.C = &vector{b.C.X, b.C.Y}
+		cb.V = &vector{b.V.X, b.V.Y}
+		cb.acc = nil
+		balls[i] = &cb
+	}
+
+	return &Snapshot{
+		Config: sn.Config.copy(),
+		Draws:  sn.Draws,
+		Frames: sn.Frames,
+		Balls:  balls,
+		NextID: sn.NextID,
+	}
+}
@@ -0,0 +1,92 @@
+package game
+
+import (
+	"encoding/json"
+	"math"
+	"reflect"
+	"testing"
+)
+
+func TestSnapshotRestore(t *testing.T) {
+	c := testConfig()
+	c.BallCount = 20
+	s := newTestSimulation(t, c)
+
+	// carry on from a snapshot, spawning a random ball to check the random
+	// source state is restored too
+	carryOn := func(s *Simulation) []*Frame {
+		s.addBall(NewRandomBall(s.config, s.rand))
+		var frames []*Frame
+		for i := 0; i < 20; i++ {
+			s.step()
+			frames = append(frames, s.compressBalls())
+		}
+		return frames
+	}
+
+	for i := 0; i < 20; i++ {
+		s.step()
+	}
+	data, err := json.Marshal(s.snapshot())
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := carryOn(s)
+
+	var sn Snapshot
+	if err := json.Unmarshal(data, &sn); err != nil {
+		t.Fatal(err)
+	}
+	restored, err := NewSimulationFromSnapshot(&sn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if restored.frames != 20 {
+		t.Error("Expected frame counter 20, got", restored.frames)
+	}
+	if !reflect.DeepEqual(carryOn(restored), expected) {
+		t.Error("Expected restored simulation to compute the same frames")
+	}
+}
+
+func TestInvalidSnapshot(t *testing.T) {
+	if _, err := NewSimulationFromSnapshot(&Snapshot{}); err == nil {
+		t.Error("Expected an error for a snapshot without config")
+	}
+	sn := &Snapshot{Config: testConfig(), Balls: []*Ball{{Radius: 1}}}
+	if _, err := NewSimulationFromSnapshot(sn); err == nil {
+		t.Error("Expected an error for a ball without position")
+	}
+
+	ball := func(id int, radius, mass float64) *Ball {
+		return &Ball{Id: id, C: &vector{1, 1}, V: &vector{1, 1}, Radius: radius, Mass: mass, Color: "#ff0000"}
+	}
+	for name, sn := range map[string]*Snapshot{
+		"endless draws": {Config: testConfig(), Draws: 1 << 62},
+		"zero radius":   {Config: testConfig(), Balls: []*Ball{ball(0, 0, 1)}},
+		"zero mass":     {Config: testConfig(), Balls: []*Ball{ball(0, 1, 0)}},
+		"infinite mass": {Config: testConfig(), Balls: []*Ball{ball(0, 1, math.Inf(1))}},
+		"NaN position":  {Config: testConfig(), Balls: []*Ball{{C: &vector{math.NaN(), 1}, V: &vector{}, Radius: 1, Mass: 1, Color: "red"}}},
+		"negative ID":   {Config: testConfig(), Balls: []*Ball{ball(-1, 1, 1)}},
+		"duplicate IDs": {Config: testConfig(), Balls: []*Ball{ball(3, 1, 1), ball(3, 1, 1)}},
+		"invalid color": {Config: testConfig(), Balls: []*Ball{{Id: 0, C: &vector{}, V: &vector{}, Radius: 1, Mass: 1, Color: "red;"}}},
+		"huge radius":   {Config: testConfig(), Balls: []*Ball{ball(0, 100, 1)}},
+	} {
+		if _, err := NewSimulationFromSnapshot(sn); err == nil {
+			t.Errorf("Expected an error for a snapshot with %s", name)
+		}
+	}
+	// balls lighter than the config ones are left over from a config change
+	if _, err := NewSimulationFromSnapshot(&Snapshot{Config: testConfig(), Balls: []*Ball{ball(0, 0.1, 0.1)}}); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestRestoreStopped(t *testing.T) {
+	s := startedTestSimulation(t, testConfig())
+	sn := s.Snapshot()
+	s.Stop()
+	if err := s.Restore(sn); err != errStopped {
+		t.Errorf("Expected %v restoring a stopped simulation, got %v", errStopped, err)
+	}
+}
@@ -31,6 +31,17 @@ func (v *vector) Normalise() *vector {
 	return v
 }
 
+// cross returns the z component of the cross product of v and u.
+func (v *vector) cross(u *vector) float64 {
+	return v.X*u.Y - v.Y*u.X
+}
+
+// cross returns the velocity w × r of the point at r on a body spinning at w
+// radians/s.
+func cross(w float64, r *vector) *vector {
+	return &vector{-w * r.Y, w * r.X}
+}
+
 func (v *vector) add(u *vector) *vector {
 	return &vector{v.X + u.X, v.Y + u.Y}
 }
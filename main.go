@@ -12,6 +12,10 @@ import (
 )
 
 var addr = flag.String("addr", ":8080", "http service address")
+var recordsDir = flag.String("records", "records", "directory of the recorded frame streams")
+
+// file extension of the recordings
+const recordExt = ".rec"
 
 func serveCanvas(w http.ResponseWriter, r *http.Request) {
 	if r.Method != "GET" {
@@ -64,11 +64,17 @@ func (b *Box) IntersectsBox(other *Box) bool {
 	return true
 }
 
+// maxDepth is the deepest a node is subdivided to. Nodes that deep keep every
+// point inserted, beyond their capacity, so that many points at the same
+// position do not subdivide the tree without end.
+const maxDepth = 16
+
 // QuadTree represents the quadtree data structure.
 type QuadTree struct {
 	boundary     Box
 	points       []Point
 	nodeCapacity int
+	depth        int
 	northWest    *QuadTree
 	northEast    *QuadTree
 	southWest    *QuadTree
@@ -96,8 +102,9 @@ func (qt *QuadTree) Insert(p Point) bool {
 		return false
 	}
 
-	// If there is space in this quad tree, add the object here.
-	if len(qt.points) < cap(qt.points) {
+	// If there is space in this quad tree, or it cannot be subdivided
+	// anymore, add the object here.
+	if len(qt.points) < cap(qt.points) || qt.depth >= maxDepth {
 		qt.points = append(qt.points, p)
 		return true
 	}
@@ -138,7 +145,7 @@ func (qt *QuadTree) subDivide() {
 		qt.boundary.HalfX / 2,
 		qt.boundary.HalfY / 2,
 	}
-	qt.northWest = New(box, qt.nodeCapacity)
+	qt.northWest = qt.child(box)
 
 	box = Box{
 		qt.boundary.CenterX + qt.boundary.HalfX/2,
@@ -146,7 +153,7 @@ func (qt *QuadTree) subDivide() {
 		qt.boundary.HalfX / 2,
 		qt.boundary.HalfY / 2,
 	}
-	qt.northEast = New(box, qt.nodeCapacity)
+	qt.northEast = qt.child(box)
 
 	box = Box{
 		qt.boundary.CenterX - qt.boundary.HalfX/2,
@@ -154,7 +161,7 @@ func (qt *QuadTree) subDivide() {
 		qt.boundary.HalfX / 2,
 		qt.boundary.HalfY / 2,
 	}
-	qt.southWest = New(box, qt.nodeCapacity)
+	qt.southWest = qt.child(box)
 
 	box = Box{
 		qt.boundary.CenterX + qt.boundary.HalfX/2,
@@ -162,7 +169,7 @@ func (qt *QuadTree) subDivide() {
 		qt.boundary.HalfX / 2,
 		qt.boundary.HalfY / 2,
 	}
-	qt.southEast = New(box, qt.nodeCapacity)
+	qt.southEast = qt.child(box)
 
 	for _, v := range qt.points {
 		if qt.northWest.Insert(v) {
@@ -181,18 +188,25 @@ func (qt *QuadTree) subDivide() {
 	qt.points = nil
 }
 
-func (q *QuadTree) isLeaf() {
-	return qt.northWest == nil
+// child returns a node bounded by box, one level deeper than qt.
+func (qt *QuadTree) child(box Box) *QuadTree {
+	c := New(box, qt.nodeCapacity)
+	c.depth = qt.depth + 1
+	return c
+}
+
+func (q *QuadTree) isLeaf() bool {
+	return q.northWest == nil
 }
 
 func (q *QuadTree) leafs() []*QuadTree {
 	leafs := []*QuadTree{}
 
 	if !q.isLeaf() {
-		leafs = append(leafs, qt.northWest.leafs())
-		leafs = append(leafs, qt.northEast.leafs())
-		leafs = append(leafs, qt.southWest.leafs())
-		leafs = append(leafs, qt.southEast.leafs())
+		leafs = append(leafs, q.northWest.leafs()...)
+		leafs = append(leafs, q.northEast.leafs()...)
+		leafs = append(leafs, q.southWest.leafs()...)
+		leafs = append(leafs, q.southEast.leafs()...)
 	} else {
 		leafs = append(leafs, q)
 	}
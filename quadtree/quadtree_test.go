@@ -0,0 +1,20 @@
+package quadtree
+
+import "testing"
+
+type point struct{ x, y float64 }
+
+func (p point) X() float64 { return p.x }
+func (p point) Y() float64 { return p.y }
+
+func TestInsertSamePoint(t *testing.T) {
+	qt := New(Box{0, 0, 100, 100}, 4)
+	for i := 0; i < 100; i++ {
+		if !qt.Insert(point{1, 1}) {
+			t.Fatal("Expected the point to be inserted")
+		}
+	}
+	if found := qt.SearchArea(NewBox(1, 1, 0.5, 0.5)); len(found) != 100 {
+		t.Error("Expected the 100 points to be found, got", len(found))
+	}
+}
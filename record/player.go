@@ -0,0 +1,136 @@
+package record
+
+import (
+	"math"
+	"time"
+
+	"github.com/adriangonzy/websocket-balls/game"
+)
+
+// Player streams a recording back at its original pace, scaled by its speed.
+type Player struct {
+	rec *Recording
+	// Emit streams the recorded frames.
+	Emit    chan *game.Frame
+	done    chan bool
+	stopped chan struct{}
+	actions chan func()
+	pos     int // next frame to emit
+	speed   float64
+	paused  bool
+}
+
+func NewPlayer(rec *Recording, speed float64) *Player {
+	return &Player{
+		rec:     rec,
+		Emit:    make(chan *game.Frame),
+		done:    make(chan bool),
+		stopped: make(chan struct{}),
+		actions: make(chan func()),
+		speed:   clampSpeed(speed),
+	}
+}
+
+func (p *Player) Start() {
+	go func() {
+		defer close(p.stopped)
+		for {
+			// wait for the next frame unless paused or at the end
+			var next <-chan time.Time
+			if !p.paused && p.pos < len(p.rec.Frames) {
+				next = time.After(p.delay())
+			}
+
+			select {
+			case f := <-p.actions:
+				f()
+			case <-next:
+				p.emit()
+			case <-p.done:
+				return
+			}
+		}
+	}()
+}
+
+// Stop ends the replay and closes Emit.
+func (p *Player) Stop() {
+	p.done <- true
+	close(p.Emit)
+}
+
+// delay is the recorded time between the last emitted frame and the next
+// one, scaled by the speed.
+func (p *Player) delay() time.Duration {
+	if p.pos == 0 {
+		return 0
+	}
+	d := p.rec.Frames[p.pos].T - p.rec.Frames[p.pos-1].T
+	return time.Duration(float64(d) / p.speed)
+}
+
+// emit streams the next frame.
+func (p *Player) emit() {
+	p.Emit <- p.rec.Frames[p.pos].Frame
+	p.pos++
+}
+
+func (p *Player) do(f func()) {
+	select {
+	case p.actions <- f:
+	case <-p.stopped:
+	}
+}
+
+// Pause freezes the replay until Resume is called.
+func (p *Player) Pause() {
+	p.do(func() {
+		p.paused = true
+	})
+}
+
+// Resume restarts a paused replay.
+func (p *Player) Resume() {
+	p.do(func() {
+		p.paused = false
+	})
+}
+
+// Step pauses the replay then emits the next n frames.
+func (p *Player) Step(n int) {
+	p.do(func() {
+		p.paused = true
+		for i := 0; i < n && p.pos < len(p.rec.Frames); i++ {
+			p.emit()
+		}
+	})
+}
+
+// Seek emits the given frame, the replay carrying on from there.
+func (p *Player) Seek(frame int) {
+	p.do(func() {
+		if frame < 0 {
+			frame = 0
+		}
+		if frame >= len(p.rec.Frames) {
+			frame = len(p.rec.Frames) - 1
+		}
+		p.pos = frame
+		p.emit()
+	})
+}
+
+// SetTimeScale changes the replay speed, with the same bounds as the
+// simulation time scale.
+func (p *Player) SetTimeScale(speed float64) {
+	p.do(func() {
+		p.speed = clampSpeed(speed)
+	})
+}
+
+func clampSpeed(speed float64) float64 {
+	if speed == 0 {
+		return 1
+	}
+	return math.Max(game.MinTimeScale, math.Min(game.MaxTimeScale, speed))
+}
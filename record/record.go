@@ -0,0 +1,119 @@
+// Package record saves simulation frame streams to disk and loads them back
+// for replay.
+//
+// A recording is a gzip compressed file of JSON lines: a header holding the
+// simulation config, then one line per frame with its time offset since the
+// start of the recording.
+package record
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/adriangonzy/websocket-balls/game"
+)
+
+// Version of the recording format.
+const Version = 2
+
+var errEmpty = errors.New("empty recording")
+
+// Header is the first line of a recording.
+type Header struct {
+	Version int          `json:"version"`
+	Config  *game.Config `json:"config"`
+	Started time.Time    `json:"started"`
+}
+
+// Frame is a recorded frame.
+type Frame struct {
+	T     time.Duration `json:"t"` // since the start of the recording
+	Frame *game.Frame   `json:"f"`
+}
+
+// Recorder writes frames to a recording file.
+type Recorder struct {
+	file    *os.File
+	gz      *gzip.Writer
+	enc     *json.Encoder
+	started time.Time
+}
+
+// NewRecorder creates the recording file at path and writes its header.
+func NewRecorder(path string, c *game.Config) (*Recorder, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	gz := gzip.NewWriter(f)
+	r := &Recorder{
+		file:    f,
+		gz:      gz,
+		enc:     json.NewEncoder(gz),
+		started: time.Now(),
+	}
+	if err := r.enc.Encode(Header{Version, c, r.started}); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return r, nil
+}
+
+// Write records a frame.
+func (r *Recorder) Write(frame *game.Frame) error {
+	return r.enc.Encode(Frame{time.Since(r.started), frame})
+}
+
+// Close flushes the recording and closes its file.
+func (r *Recorder) Close() error {
+	if err := r.gz.Close(); err != nil {
+		r.file.Close()
+		return err
+	}
+	return r.file.Close()
+}
+
+// Recording is a recording loaded in memory.
+type Recording struct {
+	Header Header
+	Frames []Frame
+}
+
+// Load reads the recording file at path.
+func Load(path string) (*Recording, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	gz, err := gzip.NewReader(bufio.NewReader(f))
+	if err != nil {
+		return nil, err
+	}
+	defer gz.Close()
+
+	dec := json.NewDecoder(gz)
+	var rec Recording
+	if err := dec.Decode(&rec.Header); err != nil {
+		return nil, err
+	}
+	if rec.Header.Version != Version {
+		return nil, fmt.Errorf("unsupported recording version %d", rec.Header.Version)
+	}
+	for dec.More() {
+		var frame Frame
+		if err := dec.Decode(&frame); err != nil {
+			return nil, err
+		}
+		rec.Frames = append(rec.Frames, frame)
+	}
+	if len(rec.Frames) == 0 {
+		return nil, errEmpty
+	}
+	return &rec, nil
+}
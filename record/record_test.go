@@ -0,0 +1,73 @@
+package record
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/adriangonzy/websocket-balls/game"
+)
+
+func writeTestRecording(t *testing.T, frames ...*game.Frame) string {
+	path := filepath.Join(t.TempDir(), "test.rec")
+	r, err := NewRecorder(path, &game.Config{FrameRate: 30, BallCount: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range frames {
+		if err := r.Write(f); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestRecordAndLoad(t *testing.T) {
+	ball := game.BallState{Id: 1, X: 2, Y: 2, Radius: 3, Color: "#fff"}
+	rec, err := Load(writeTestRecording(t, &game.Frame{Number: 1}, &game.Frame{Number: 2, Balls: []game.BallState{ball}}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rec.Header.Config.BallCount != 1 {
+		t.Error("Expected the recorded config, got", rec.Header.Config)
+	}
+	if len(rec.Frames) != 2 || !reflect.DeepEqual(rec.Frames[1].Frame.Balls, []game.BallState{ball}) {
+		t.Error("Expected the 2 recorded frames, got", rec.Frames)
+	}
+	if rec.Frames[1].T < rec.Frames[0].T {
+		t.Error("Expected increasing frame times, got", rec.Frames[0].T, rec.Frames[1].T)
+	}
+}
+
+func TestPlayerStepAndSeek(t *testing.T) {
+	rec, err := Load(writeTestRecording(t, &game.Frame{Number: 0}, &game.Frame{Number: 1}, &game.Frame{Number: 2}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := NewPlayer(rec, 1)
+	p.paused = true
+	p.Start()
+	defer p.Stop()
+
+	next := func() int {
+		select {
+		case f := <-p.Emit:
+			return f.Number
+		case <-time.After(time.Second):
+			return -1
+		}
+	}
+
+	go p.Step(2)
+	if f0, f1 := next(), next(); f0 != 0 || f1 != 1 {
+		t.Error("Expected frames 0 and 1, got", f0, f1)
+	}
+	go p.Seek(0)
+	if f := next(); f != 0 {
+		t.Error("Expected frame 0 after seeking, got", f)
+	}
+}
@@ -0,0 +1,420 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/adriangonzy/websocket-balls/game"
+	"github.com/adriangonzy/websocket-balls/record"
+	"github.com/adriangonzy/websocket-balls/ws"
+)
+
+var (
+	errUnknownSession = errors.New("unknown simulation session")
+	errNotRunning     = errors.New("simulation is not running")
+	errNoConfig       = errors.New("no simulation config given")
+	errNotReplaying   = errors.New("session is not replaying a recording")
+	errNotRecording   = errors.New("session is not recording")
+	errNoBall         = errors.New("no ball ID given")
+)
+
+// playback is the part of the session stream that pause, resume, step and
+// time scale commands drive: a simulation or a replay.
+type playback interface {
+	Pause()
+	Resume()
+	Step(n int)
+	SetTimeScale(scale float64)
+}
+
+// session is a simulation, or the replay of a recorded one, along with the
+// websocket viewers subscribed to its frames. A session outlives the
+// simulations started and stopped in it. Sessions created over HTTP are kept
+// until stopped, the ones created by a websocket viewer or replaying a
+// recording are closed once their last viewer leaves.
+type session struct {
+	id  string
+	hub *ws.Hub
+
+	mu     sync.Mutex
+	config *game.Config
+	sim    *game.Simulation
+	player *record.Player
+
+	// the recorder is written by the frame emitter goroutine, which must not
+	// wait for mu while a simulation is being stopped
+	recMu    sync.Mutex
+	recorder *record.Recorder
+}
+
+// start runs a new simulation with the given config, or with the last config
+// set on the session when c is nil, replacing any running one.
+func (s *session) start(c *game.Config) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if c == nil {
+		c = s.config
+	}
+	if c == nil {
+		return errNoConfig
+	}
+
+	sim, err := game.NewSimulation(c)
+	if err != nil {
+		return err
+	}
+	s.config = c
+	s.run(sim)
+	return nil
+}
+
+// restore runs the snapshot state, in place of the running simulation if any.
+func (s *session) restore(sn *game.Snapshot) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.sim != nil {
+		if err := s.sim.Restore(sn); err != nil {
+			return err
+		}
+		s.config = sn.Config
+		s.announceWorld()
+		return nil
+	}
+	sim, err := game.NewSimulationFromSnapshot(sn)
+	if err != nil {
+		return err
+	}
+	s.config = sn.Config
+	s.run(sim)
+	return nil
+}
+
+// run starts streaming sim frames to the viewers, replacing any running
+// simulation or replay. It must be called with mu held.
+func (s *session) run(sim *game.Simulation) {
+	s.stopLocked()
+
+	s.sim = sim
+	go func() {
+		for f := range sim.Emit {
+			s.record(f)
+			s.hub.Broadcast(newEncodedFrame(f))
+		}
+	}()
+	sim.Start()
+	s.announceWorld()
+}
+
+// announceWorld sends the world geometry of the session config to the
+// viewers. It must be called with mu held.
+func (s *session) announceWorld() {
+	if s.config == nil {
+		return
+	}
+	s.hub.Notify(serializeReply(worldReply(s.config)))
+}
+
+// world returns the world geometry message of the session config, nil when
+// no config is set yet.
+func (s *session) world() []byte {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.config == nil {
+		return nil
+	}
+	return serializeReply(worldReply(s.config))
+}
+
+// replay streams a recording to the viewers, replacing any running
+// simulation or replay.
+func (s *session) replay(rec *record.Recording, speed float64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.stopLocked()
+
+	s.config = rec.Header.Config
+	s.announceWorld()
+	s.player = record.NewPlayer(rec, speed)
+	go func(p *record.Player) {
+		for f := range p.Emit {
+			s.hub.Broadcast(newEncodedFrame(f))
+		}
+	}(s.player)
+	s.player.Start()
+}
+
+// stop stops the running simulation or replay, keeping the session and its
+// viewers.
+func (s *session) stop() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.stopLocked()
+}
+
+// stopLocked stops the running simulation or replay, along with its
+// recording. It must be called with mu held.
+func (s *session) stopLocked() error {
+	s.stopRecording()
+	switch {
+	case s.sim != nil:
+		s.sim.Stop()
+		s.sim = nil
+	case s.player != nil:
+		s.player.Stop()
+		s.player = nil
+	default:
+		return errNotRunning
+	}
+	return nil
+}
+
+// simulation returns the running simulation.
+func (s *session) simulation() (*game.Simulation, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.sim == nil {
+		return nil, errNotRunning
+	}
+	return s.sim, nil
+}
+
+// playback returns the running simulation or replay.
+func (s *session) playback() (playback, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch {
+	case s.sim != nil:
+		return s.sim, nil
+	case s.player != nil:
+		return s.player, nil
+	}
+	return nil, errNotRunning
+}
+
+// replayer returns the running replay.
+func (s *session) replayer() (*record.Player, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.player == nil {
+		return nil, errNotReplaying
+	}
+	return s.player, nil
+}
+
+// startRecording records the frames of the running simulation in the
+// recordings directory, under the given name.
+func (s *session) startRecording(name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.sim == nil {
+		return errNotRunning
+	}
+	if err := os.MkdirAll(*recordsDir, 0755); err != nil {
+		return err
+	}
+	r, err := record.NewRecorder(recordPath(name), s.config)
+	if err != nil {
+		return err
+	}
+
+	s.stopRecording()
+	s.recMu.Lock()
+	s.recorder = r
+	s.recMu.Unlock()
+	return nil
+}
+
+// stopRecording closes the current recording, if any.
+func (s *session) stopRecording() error {
+	s.recMu.Lock()
+	defer s.recMu.Unlock()
+
+	if s.recorder == nil {
+		return errNotRecording
+	}
+	err := s.recorder.Close()
+	s.recorder = nil
+	return err
+}
+
+// record writes a frame to the current recording, if any.
+func (s *session) record(frame *game.Frame) {
+	s.recMu.Lock()
+	defer s.recMu.Unlock()
+
+	if s.recorder == nil {
+		return
+	}
+	if err := s.recorder.Write(frame); err != nil {
+		log.Println("Error recording frame", err)
+		s.recorder.Close()
+		s.recorder = nil
+	}
+}
+
+// recordPath returns the path of a recording in the recordings directory,
+// ignoring any directory in name.
+func recordPath(name string) string {
+	name = filepath.Base(name)
+	if !strings.HasSuffix(name, recordExt) {
+		name += recordExt
+	}
+	return filepath.Join(*recordsDir, name)
+}
+
+// updateConfig decodes the fields of a JSON config over the session one, and
+// applies the result to the running simulation if any. It returns the new
+// config, used by the next start as well.
+func (s *session) updateConfig(patch []byte) (*game.Config, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c := game.NewConfig()
+	switch {
+	case s.sim != nil:
+		if c = s.sim.Config(); c == nil {
+			return nil, errNotRunning
+		}
+	case s.config != nil:
+		// decode over a copy, the previous config may be shared
+		*c = *s.config
+		c.Fields = append([]game.Field(nil), c.Fields...)
+		c.Obstacles = append([]game.Obstacle(nil), c.Obstacles...)
+	}
+	if err := patchConfig(c, patch); err != nil {
+		return nil, err
+	}
+	if s.sim != nil {
+		if err := s.sim.SetConfig(c); err != nil {
+			return nil, err
+		}
+	}
+	s.config = c
+	s.announceWorld()
+	return c, nil
+}
+
+// serve dispatches the commands sent by the session viewers until the session
+// is closed.
+func (s *session) serve() {
+	for {
+		select {
+		case m := <-s.hub.Receive:
+			if err := s.handle(m.Conn, m.Data); err != nil {
+				s.hub.SendTo(m.Conn, serializeReply(errorReply(err)))
+			}
+		case <-s.hub.Done():
+			return
+		}
+	}
+}
+
+// close stops the simulation and disconnects the viewers.
+func (s *session) close() {
+	s.stop()
+	s.hub.Stop()
+}
+
+// sessionManager keeps track of the concurrently running sessions.
+type sessionManager struct {
+	mu       sync.Mutex
+	sessions map[string]*session
+}
+
+func newSessionManager() *sessionManager {
+	return &sessionManager{sessions: make(map[string]*session)}
+}
+
+// create registers a new session with no running simulation.
+func (m *sessionManager) create() *session {
+	s := &session{
+		id:  newSessionID(),
+		hub: ws.NewHub(),
+	}
+
+	m.mu.Lock()
+	m.sessions[s.id] = s
+	m.mu.Unlock()
+
+	go s.hub.Run()
+	go s.serve()
+	return s
+}
+
+// reap closes the session once left is signalled, usually by its hub when
+// the last viewer leaves, unless the session is stopped first.
+func (m *sessionManager) reap(s *session, left <-chan struct{}) {
+	select {
+	case <-left:
+		log.Println("Closing session", s.id, "left by its last viewer")
+		m.stop(s.id)
+	case <-s.hub.Done():
+	}
+}
+
+// replay creates a new session replaying the recording at the given speed,
+// closed once its last viewer leaves.
+func (m *sessionManager) replay(rec *record.Recording, speed float64) *session {
+	s := m.create()
+	s.replay(rec, speed)
+	go m.reap(s, s.hub.Empty())
+	return s
+}
+
+// start creates a new session running a simulation with the given config and
+// streams its frames to the session subscribers.
+func (m *sessionManager) start(c *game.Config) (*session, error) {
+	s := m.create()
+	if err := s.start(c); err != nil {
+		m.stop(s.id)
+		return nil, err
+	}
+	return s, nil
+}
+
+func (m *sessionManager) get(id string) (*session, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s, ok := m.sessions[id]
+	if !ok {
+		return nil, errUnknownSession
+	}
+	return s, nil
+}
+
+// stop closes the session and forgets about it.
+func (m *sessionManager) stop(id string) error {
+	m.mu.Lock()
+	s, ok := m.sessions[id]
+	delete(m.sessions, id)
+	m.mu.Unlock()
+	if !ok {
+		return errUnknownSession
+	}
+
+	s.close()
+	return nil
+}
+
+func newSessionID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// waitClosed fails unless the session hub stops within a second.
+func waitClosed(t *testing.T, s *session) {
+	t.Helper()
+	select {
+	case <-s.hub.Done():
+	case <-time.After(time.Second):
+		t.Fatal("Expected the session to be closed")
+	}
+}
+
+func TestReapOnLastViewer(t *testing.T) {
+	m := newSessionManager()
+	s := m.create()
+	left := make(chan struct{})
+	go m.reap(s, left)
+
+	left <- struct{}{}
+	waitClosed(t, s)
+	if _, err := m.get(s.id); err != errUnknownSession {
+		t.Error("Expected the session to be forgotten, got", err)
+	}
+}
+
+func TestReapStoppedSession(t *testing.T) {
+	m := newSessionManager()
+	s := m.create()
+	reaped := make(chan bool)
+	go func() {
+		m.reap(s, nil)
+		close(reaped)
+	}()
+
+	if err := m.stop(s.id); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-reaped:
+	case <-time.After(time.Second):
+		t.Error("Expected reap to return once the session is stopped")
+	}
+}
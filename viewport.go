@@ -0,0 +1,99 @@
+package main
+
+import (
+	"errors"
+	"math"
+	"sort"
+
+	"github.com/adriangonzy/websocket-balls/game"
+	"github.com/adriangonzy/websocket-balls/quadtree"
+)
+
+// viewportMargin is the part of the viewport size added on every side of it,
+// so that balls about to enter the viewport are already known to the viewer.
+const viewportMargin = 0.25
+
+var errInvalidViewport = errors.New("viewport width and height must be positive")
+
+// viewport is the part of the world a viewer displays: its top left corner
+// in world pixels, its size in screen pixels and the screen pixels per world
+// pixel.
+type viewport struct {
+	X      float64 `json:"x"`
+	Y      float64 `json:"y"`
+	Width  float64 `json:"width"`
+	Height float64 `json:"height"`
+	Zoom   float64 `json:"zoom"`
+}
+
+// area returns the world area whose balls are sent to the viewer, margin
+// included.
+func (v *viewport) area() (*quadtree.Box, error) {
+	if v.Width <= 0 || v.Height <= 0 {
+		return nil, errInvalidViewport
+	}
+	zoom := v.Zoom
+	if zoom <= 0 {
+		zoom = 1
+	}
+	w, h := v.Width/zoom, v.Height/zoom
+	return quadtree.NewBox(v.X+w/2, v.Y+h/2, w*(0.5+viewportMargin), h*(0.5+viewportMargin)), nil
+}
+
+// ballPoint is a frame ball stored in a quadtree.
+type ballPoint struct {
+	x, y  float64
+	index int
+}
+
+func (p *ballPoint) X() float64 { return p.x }
+func (p *ballPoint) Y() float64 { return p.y }
+
+// ballTree indexes the balls of a frame by position.
+type ballTree struct {
+	tree *quadtree.QuadTree
+	// the largest radius, by which areas are grown so that balls partly
+	// inside them are found
+	maxRadius float64
+}
+
+func newBallTree(f *game.Frame) *ballTree {
+	minX, minY := math.Inf(1), math.Inf(1)
+	maxX, maxY := math.Inf(-1), math.Inf(-1)
+	t := &ballTree{}
+	for _, b := range f.Balls {
+		minX, maxX = math.Min(minX, b.X), math.Max(maxX, b.X)
+		minY, maxY = math.Min(minY, b.Y), math.Max(maxY, b.Y)
+		t.maxRadius = math.Max(t.maxRadius, b.Radius)
+	}
+	if len(f.Balls) == 0 {
+		minX, minY, maxX, maxY = 0, 0, 0, 0
+	}
+
+	t.tree = quadtree.New(quadtree.Box{
+		CenterX: (minX + maxX) / 2,
+		CenterY: (minY + maxY) / 2,
+		HalfX:   (maxX-minX)/2 + 1,
+		HalfY:   (maxY-minY)/2 + 1,
+	}, 10)
+	for i, b := range f.Balls {
+		t.tree.Insert(&ballPoint{b.X, b.Y, i})
+	}
+	return t
+}
+
+// search returns the indexes of the balls of the frame inside the area, in
+// frame order.
+func (t *ballTree) search(area *quadtree.Box) []int {
+	grown := *area
+	grown.HalfX += t.maxRadius
+	grown.HalfY += t.maxRadius
+
+	points := t.tree.SearchArea(&grown)
+	indexes := make([]int, len(points))
+	for i, p := range points {
+		indexes[i] = p.(*ballPoint).index
+	}
+	sort.Ints(indexes)
+	return indexes
+}
@@ -1,7 +1,9 @@
 package ws
 
 import (
+	"fmt"
 	"github.com/gorilla/websocket"
+	"log"
 	"time"
 )
 
@@ -15,8 +17,9 @@ const (
 	// Send pings to peer with this period. Must be less than pongWait.
 	pingPeriod = (pongWait * 9) / 10
 
-	// Maximum message size allowed from peer.
-	maxMessageSize = 512
+	// Maximum message size allowed from peer, large enough for commands
+	// carrying a whole config with its obstacles.
+	maxMessageSize = 64 << 10
 )
 
 var Upgrader = websocket.Upgrader{
@@ -26,35 +29,104 @@ var Upgrader = websocket.Upgrader{
 
 // connection is an middleman between the websocket connection and the hub.
 type Connection struct {
+	// Messages dropped so far, accessed atomically and kept first for 64-bit
+	// alignment.
+	dropped uint64
+
+	// The hub the connection is registered to.
+	hub *Hub
+
 	// The websocket connection.
 	ws *websocket.Conn
 
-	// Buffered channel of outbound messages.
-	Send chan []byte
+	// Buffered channel of outbound messages, turned into websocket messages
+	// by the encoder.
+	Send chan interface{}
+
+	encoder Encoder
+
+	// What to do with the messages sent while the Send buffer is full,
+	// see SetPolicy.
+	policy    Policy
+	maxMissed int
+
+	// Messages dropped in a row by the Disconnect policy, only accessed by
+	// the hub goroutine.
+	missed int
+
+	// The latest broadcast message not written yet, Coalesce policy only.
+	latest chan interface{}
+	// The broadcast message taken from latest, written once Send is empty.
+	// Only accessed by the write goroutine.
+	held interface{}
+}
+
+// Encoder turns the values sent to a connection into websocket messages.
+type Encoder interface {
+	Encode(v interface{}) (messageType int, data []byte, err error)
 }
 
-func NewConnection(send chan []byte, ws *websocket.Conn) *Connection {
-	return &Connection{Send: send, ws: ws}
+// TextEncoder sends byte slices as text messages.
+type TextEncoder struct{}
+
+func (TextEncoder) Encode(v interface{}) (int, []byte, error) {
+	data, ok := v.([]byte)
+	if !ok {
+		return 0, nil, fmt.Errorf("cannot encode %T", v)
+	}
+	return websocket.TextMessage, data, nil
 }
 
+func NewConnection(hub *Hub, send chan interface{}, ws *websocket.Conn, enc Encoder) *Connection {
+	return &Connection{hub: hub, Send: send, ws: ws, encoder: enc, policy: Disconnect, maxMissed: 1}
+}
+
+// Encoder returns the encoder of the connection messages.
+func (c *Connection) Encoder() Encoder {
+	return c.encoder
+}
+
+// Subprotocol returns the subprotocol negotiated with the peer.
+func (c *Connection) Subprotocol() string {
+	return c.ws.Subprotocol()
+}
+
+// Start registers the connection to its hub and pumps messages until the peer
+// goes away.
 func (c *Connection) Start() {
+	select {
+	case c.hub.register <- c:
+	case <-c.hub.done:
+		c.ws.Close()
+		return
+	}
 	go c.writePump()
 	c.readPump()
 }
 
-// readPump pumps messages from the websocket connection to the hub.
+// readPump pumps messages from the websocket connection to the hub Receive
+// channel.
 func (c *Connection) readPump() {
 	defer func() {
+		select {
+		case c.hub.unregister <- c:
+		case <-c.hub.done:
+		}
 		c.ws.Close()
 	}()
 	c.ws.SetReadLimit(maxMessageSize)
 	c.ws.SetReadDeadline(time.Now().Add(pongWait))
 	c.ws.SetPongHandler(func(string) error { c.ws.SetReadDeadline(time.Now().Add(pongWait)); return nil })
 	for {
-		_, _, err := c.ws.ReadMessage()
+		_, message, err := c.ws.ReadMessage()
 		if err != nil {
 			break
 		}
+		select {
+		case c.hub.Receive <- Message{c, message}:
+		case <-c.hub.done:
+			return
+		}
 	}
 }
 
@@ -64,6 +136,17 @@ func (c *Connection) write(mt int, payload []byte) error {
 	return c.ws.WriteMessage(mt, payload)
 }
 
+// writeMessage encodes and writes a message sent to the connection. Messages
+// which cannot be encoded are skipped.
+func (c *Connection) writeMessage(message interface{}) error {
+	mt, data, err := c.encoder.Encode(message)
+	if err != nil {
+		log.Println("Error encoding message", err)
+		return nil
+	}
+	return c.write(mt, data)
+}
+
 // writePump pumps messages from the hub to the websocket connection.
 func (c *Connection) writePump() {
 	ticker := time.NewTicker(pingPeriod)
@@ -72,19 +155,48 @@ func (c *Connection) writePump() {
 		c.ws.Close()
 	}()
 	for {
+		message, ok := c.next(ticker.C)
+		if !ok {
+			c.write(websocket.CloseMessage, []byte{})
+			return
+		}
+		var err error
+		if _, isPing := message.(ping); isPing {
+			err = c.write(websocket.PingMessage, []byte{})
+		} else {
+			err = c.writeMessage(message)
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// ping is the message next returns when the peer is due a ping.
+type ping struct{}
+
+// next waits for the next message to write, ok being false once Send is
+// closed. The messages sent to the connection go before the coalesced
+// broadcast ones, which come after them, e.g. the session replies before any
+// frame.
+func (c *Connection) next(pings <-chan time.Time) (message interface{}, ok bool) {
+	for {
+		select {
+		case message, ok := <-c.Send:
+			return message, ok
+		default:
+		}
+		if c.held != nil {
+			message, c.held = c.held, nil
+			return message, true
+		}
 		select {
 		case message, ok := <-c.Send:
-			if !ok {
-				c.write(websocket.CloseMessage, []byte{})
-				return
-			}
-			if err := c.write(websocket.TextMessage, message); err != nil {
-				return
-			}
-		case <-ticker.C:
-			if err := c.write(websocket.PingMessage, []byte{}); err != nil {
-				return
-			}
+			return message, ok
+		case c.held = <-c.latest:
+			// write the messages sent meanwhile first
+		case <-pings:
+			return ping{}, true
 		}
 	}
 }
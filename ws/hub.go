@@ -0,0 +1,168 @@
+package ws
+
+import (
+	"log"
+	"sync/atomic"
+)
+
+// Message is a payload received from a connection.
+type Message struct {
+	Conn *Connection
+	Data []byte
+}
+
+// outbound is a message addressed to a single connection.
+type outbound struct {
+	conn    *Connection
+	message interface{}
+}
+
+// Hub maintains the set of active connections and broadcasts messages to
+// them.
+type Hub struct {
+	// Messages dropped so far by every connection, accessed atomically and
+	// kept first for 64-bit alignment.
+	dropped uint64
+
+	// Registered connections.
+	connections map[*Connection]bool
+
+	// Outbound messages fanned out to every registered connection.
+	broadcast chan interface{}
+
+	// Outbound messages fanned out to every registered connection, never
+	// coalesced.
+	notify chan interface{}
+
+	// Outbound messages for a single connection.
+	unicast chan outbound
+
+	// Inbound messages read from the connections, in arrival order.
+	Receive chan Message
+
+	// Register requests from the connections.
+	register chan *Connection
+
+	// Unregister requests from connections.
+	unregister chan *Connection
+
+	// Closed when the hub stops serving.
+	done chan struct{}
+
+	// Signalled when the last registered connection leaves.
+	empty chan struct{}
+}
+
+func NewHub() *Hub {
+	return &Hub{
+		connections: make(map[*Connection]bool),
+		broadcast:   make(chan interface{}),
+		notify:      make(chan interface{}),
+		unicast:     make(chan outbound),
+		Receive:     make(chan Message),
+		register:    make(chan *Connection),
+		unregister:  make(chan *Connection),
+		done:        make(chan struct{}),
+		empty:       make(chan struct{}, 1),
+	}
+}
+
+// Run serves register, unregister and outbound requests until Stop is
+// called.
+func (h *Hub) Run() {
+	for {
+		select {
+		case <-h.done:
+			for c := range h.connections {
+				h.remove(c)
+			}
+			return
+		case c := <-h.register:
+			h.connections[c] = true
+		case c := <-h.unregister:
+			h.remove(c)
+		case m := <-h.unicast:
+			if h.connections[m.conn] {
+				h.send(m.conn, m.message, false)
+			}
+		case m := <-h.broadcast:
+			for c := range h.connections {
+				h.send(c, m, true)
+			}
+		case m := <-h.notify:
+			for c := range h.connections {
+				h.send(c, m, false)
+			}
+		}
+	}
+}
+
+// Broadcast sends a message to every registered connection. It is a no-op
+// once the hub is stopped.
+func (h *Hub) Broadcast(m interface{}) {
+	select {
+	case h.broadcast <- m:
+	case <-h.done:
+	}
+}
+
+// Notify sends a message to every registered connection, which unlike
+// Broadcast ones is not replaced by the next message on slow connections. It
+// is a no-op once the hub is stopped.
+func (h *Hub) Notify(m interface{}) {
+	select {
+	case h.notify <- m:
+	case <-h.done:
+	}
+}
+
+// SendTo sends a message to a single connection if it is still registered.
+func (h *Hub) SendTo(c *Connection, m interface{}) {
+	select {
+	case h.unicast <- outbound{c, m}:
+	case <-h.done:
+	}
+}
+
+// Stop disconnects every registered connection and stops the hub.
+func (h *Hub) Stop() {
+	close(h.done)
+}
+
+// Done is closed once the hub is stopped.
+func (h *Hub) Done() <-chan struct{} {
+	return h.done
+}
+
+// Empty is signalled whenever the last registered connection leaves the hub.
+func (h *Hub) Empty() <-chan struct{} {
+	return h.empty
+}
+
+// Dropped returns the number of messages dropped so far by the connections
+// of the hub, disconnected ones included.
+func (h *Hub) Dropped() uint64 {
+	return atomic.LoadUint64(&h.dropped)
+}
+
+// send queues a message for the connection without ever blocking, the
+// connection policy deciding what to drop when the peer is not keeping up.
+func (h *Hub) send(c *Connection, m interface{}, broadcast bool) {
+	if !c.offer(m, broadcast) {
+		log.Printf("Disconnecting slow connection after %d dropped messages", c.Dropped())
+		h.remove(c)
+	}
+}
+
+func (h *Hub) remove(c *Connection) {
+	if _, ok := h.connections[c]; ok {
+		delete(h.connections, c)
+		close(c.Send)
+		if len(h.connections) == 0 {
+			select {
+			case h.empty <- struct{}{}:
+			default:
+			}
+		}
+	}
+}
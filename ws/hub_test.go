@@ -0,0 +1,56 @@
+package ws
+
+import (
+	"testing"
+	"time"
+)
+
+func TestHubEmptyOnLastConnection(t *testing.T) {
+	h := NewHub()
+	go h.Run()
+	defer h.Stop()
+	c1 := NewConnection(h, make(chan interface{}, 1), nil, TextEncoder{})
+	c2 := NewConnection(h, make(chan interface{}, 1), nil, TextEncoder{})
+	h.register <- c1
+	h.register <- c2
+
+	h.unregister <- c1
+	// served once the first connection is gone
+	h.SendTo(c2, "a")
+	<-c2.Send
+	select {
+	case <-h.Empty():
+		t.Fatal("Expected the hub not to be empty with a connection left")
+	default:
+	}
+
+	h.unregister <- c2
+	select {
+	case <-h.Empty():
+	case <-time.After(time.Second):
+		t.Error("Expected the hub to report it is empty")
+	}
+}
+
+func TestHubBroadcastToEveryConnection(t *testing.T) {
+	h := NewHub()
+	go h.Run()
+	defer h.Stop()
+	conns := make([]*Connection, 3)
+	for i := range conns {
+		conns[i] = NewConnection(h, make(chan interface{}, 1), nil, TextEncoder{})
+		h.register <- conns[i]
+	}
+
+	h.Broadcast("frame")
+	for i, c := range conns {
+		select {
+		case m := <-c.Send:
+			if m != "frame" {
+				t.Errorf("Expected connection %d to get the frame, got %v", i, m)
+			}
+		case <-time.After(time.Second):
+			t.Errorf("Expected connection %d to get the frame", i)
+		}
+	}
+}
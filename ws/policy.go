@@ -0,0 +1,117 @@
+package ws
+
+import (
+	"fmt"
+	"sync/atomic"
+)
+
+// Policy is what a connection does with the messages sent to it while its
+// send buffer is full, i.e. while the peer is not keeping up. The hub never
+// waits for a connection, so a slow peer cannot stall the others nor the
+// simulation feeding them.
+type Policy int
+
+const (
+	// DropOldest discards the oldest buffered message to make room for the
+	// new one.
+	DropOldest Policy = iota
+
+	// Coalesce keeps a single broadcast message waiting to be written,
+	// replacing it with the latest one. Messages sent to the connection
+	// alone or notified are buffered as with DropOldest.
+	Coalesce
+
+	// Disconnect discards the new message, and disconnects the peer once
+	// MaxMissed messages in a row were discarded.
+	Disconnect
+)
+
+var policyNames = []string{"drop-oldest", "coalesce", "disconnect"}
+
+func (p Policy) String() string {
+	if p < 0 || int(p) >= len(policyNames) {
+		return fmt.Sprintf("Policy(%d)", int(p))
+	}
+	return policyNames[p]
+}
+
+// ParsePolicy returns the policy with the given name, as returned by String.
+func ParsePolicy(name string) (Policy, error) {
+	for i, n := range policyNames {
+		if n == name {
+			return Policy(i), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown slow connection policy %q", name)
+}
+
+// SetPolicy sets how the connection handles a slow peer. maxMissed is only
+// used by Disconnect and defaults to 1. It must be called before Start.
+func (c *Connection) SetPolicy(p Policy, maxMissed int) {
+	if maxMissed < 1 {
+		maxMissed = 1
+	}
+	c.policy = p
+	c.maxMissed = maxMissed
+	c.latest = nil
+	if p == Coalesce {
+		c.latest = make(chan interface{}, 1)
+	}
+}
+
+// Policy returns how the connection handles a slow peer.
+func (c *Connection) Policy() Policy {
+	return c.policy
+}
+
+// Dropped returns the number of messages dropped because the peer was not
+// keeping up.
+func (c *Connection) Dropped() uint64 {
+	return atomic.LoadUint64(&c.dropped)
+}
+
+// offer queues a message without blocking, applying the connection policy
+// when the buffer is full. It returns false when the peer must be
+// disconnected. It is only called from the hub goroutine.
+func (c *Connection) offer(m interface{}, broadcast bool) bool {
+	if broadcast && c.latest != nil {
+		select {
+		case c.latest <- m:
+		default:
+			// replace the message the peer did not get to yet, the hub
+			// being the only sender there is room afterwards
+			select {
+			case <-c.latest:
+				c.drop()
+			default:
+			}
+			c.latest <- m
+		}
+		return true
+	}
+
+	select {
+	case c.Send <- m:
+		c.missed = 0
+		return true
+	default:
+	}
+
+	if c.policy == Disconnect {
+		c.drop()
+		c.missed++
+		return c.missed < c.maxMissed
+	}
+	select {
+	case <-c.Send:
+		c.drop()
+	default:
+	}
+	c.Send <- m
+	return true
+}
+
+func (c *Connection) drop() {
+	atomic.AddUint64(&c.dropped, 1)
+	atomic.AddUint64(&c.hub.dropped, 1)
+}
@@ -0,0 +1,151 @@
+package ws
+
+import (
+	"reflect"
+	"testing"
+)
+
+// fullConnection returns a connection with the given policy whose send
+// buffer of two messages is already full.
+func fullConnection(p Policy, maxMissed int) *Connection {
+	c := NewConnection(NewHub(), make(chan interface{}, 2), nil, TextEncoder{})
+	c.SetPolicy(p, maxMissed)
+	c.Send <- "a"
+	c.Send <- "b"
+	return c
+}
+
+// drain returns the buffered messages, the coalesced one last, until the
+// buffer is empty or closed.
+func drain(c *Connection) []interface{} {
+	var messages []interface{}
+	for {
+		select {
+		case m, ok := <-c.Send:
+			if ok {
+				messages = append(messages, m)
+				continue
+			}
+		default:
+		}
+		select {
+		case m := <-c.latest:
+			messages = append(messages, m)
+		default:
+		}
+		return messages
+	}
+}
+
+func TestDropOldestPolicy(t *testing.T) {
+	c := fullConnection(DropOldest, 0)
+	for _, m := range []string{"c", "d"} {
+		if !c.offer(m, true) {
+			t.Fatal("Expected drop-oldest to keep the connection")
+		}
+	}
+	if got := drain(c); !reflect.DeepEqual(got, []interface{}{"c", "d"}) {
+		t.Error("Expected the newest messages, got", got)
+	}
+	if c.Dropped() != 2 || c.hub.Dropped() != 2 {
+		t.Error("Expected 2 dropped messages, got", c.Dropped(), c.hub.Dropped())
+	}
+}
+
+func TestCoalescePolicy(t *testing.T) {
+	c := fullConnection(Coalesce, 0)
+	for _, m := range []string{"c", "d", "e"} {
+		if !c.offer(m, true) {
+			t.Fatal("Expected coalesce to keep the connection")
+		}
+	}
+	// replies sent to the connection alone are buffered as with drop-oldest
+	if !c.offer("reply", false) {
+		t.Fatal("Expected coalesce to keep the connection")
+	}
+	if got := drain(c); !reflect.DeepEqual(got, []interface{}{"b", "reply", "e"}) {
+		t.Error("Expected the latest broadcast and the reply, got", got)
+	}
+	if c.Dropped() != 3 {
+		t.Error("Expected 3 dropped messages, got", c.Dropped())
+	}
+}
+
+func TestDisconnectPolicy(t *testing.T) {
+	c := fullConnection(Disconnect, 2)
+	if !c.offer("c", true) {
+		t.Fatal("Expected a first missed message to be tolerated")
+	}
+	// room again, the missed count starts over
+	<-c.Send
+	if !c.offer("d", true) {
+		t.Fatal("Expected the message to be buffered")
+	}
+	if !c.offer("e", true) || c.offer("f", true) {
+		t.Error("Expected a disconnection after 2 missed messages in a row")
+	}
+	if got := drain(c); !reflect.DeepEqual(got, []interface{}{"b", "d"}) {
+		t.Error("Expected the missed messages to be discarded, got", got)
+	}
+	if c.Dropped() != 3 {
+		t.Error("Expected 3 dropped messages, got", c.Dropped())
+	}
+}
+
+func TestHubDisconnectsSlowConnection(t *testing.T) {
+	c := fullConnection(Disconnect, 1)
+	h := c.hub
+	h.connections[c] = true
+
+	h.send(c, "c", true)
+	if h.connections[c] {
+		t.Error("Expected the slow connection to be removed")
+	}
+	drain(c)
+	select {
+	case _, ok := <-c.Send:
+		if ok {
+			t.Error("Expected no message after the disconnection")
+		}
+	default:
+		t.Error("Expected the send channel to be closed")
+	}
+	select {
+	case <-h.Empty():
+	default:
+		t.Error("Expected the hub to report it is empty")
+	}
+}
+
+func TestParsePolicy(t *testing.T) {
+	for _, p := range []Policy{DropOldest, Coalesce, Disconnect} {
+		if got, err := ParsePolicy(p.String()); err != nil || got != p {
+			t.Error("Expected", p, "got", got, err)
+		}
+	}
+	if _, err := ParsePolicy("wait"); err == nil {
+		t.Error("Expected an unknown policy error")
+	}
+}
+
+func TestCoalescedAfterSent(t *testing.T) {
+	c := NewConnection(NewHub(), make(chan interface{}, 2), nil, TextEncoder{})
+	c.SetPolicy(Coalesce, 0)
+	// whichever channel is found ready first
+	for i := 0; i < 100; i++ {
+		c.Send <- "session"
+		c.Send <- "world"
+		c.offer("frame", true)
+		var got []interface{}
+		for len(got) < 3 {
+			m, ok := c.next(nil)
+			if !ok {
+				t.Fatal("Expected the connection to stay open")
+			}
+			got = append(got, m)
+		}
+		if want := []interface{}{"session", "world", "frame"}; !reflect.DeepEqual(got, want) {
+			t.Fatalf("Expected %v, got %v", want, got)
+		}
+	}
+}